@@ -3,13 +3,27 @@ package cache
 import (
 	"context"
 	"fmt"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	serviceNamespace = "sparrow"
+	defaultServiceNamespace = "sparrow"
 )
 
+// KeyNamespaceOpts separates the keys of services and environments sharing a cache
+type KeyNamespaceOpts struct {
+	// Service defaults to sparrow
+	Service string
+	Env     string
+	// Version is part of every key, bumping it on deploy invalidates everything cached before
+	Version string
+}
+
+// keyPrefix is prepended to every key built by GetKey, it is read on every call so it is swapped atomically
+var keyPrefix atomic.Value
+
 type Cache interface {
 	Set(context.Context, string, interface{}, time.Duration) bool
 	Get(context.Context, string) (interface{}, bool)
@@ -17,8 +31,77 @@ type Cache interface {
 	Exists(context.Context, string) bool
 }
 
+// ExtendedCache adds key management, counters and bulk operations to Cache. It is a separate interface
+// so that existing Cache implementations keep compiling.
+type ExtendedCache interface {
+	Cache
+	Delete(context.Context, ...string) bool
+	// DeleteByPrefix removes every key starting with the prefix and returns how many were removed
+	DeleteByPrefix(context.Context, string) (int, bool)
+	// TTL returns the remaining time to live, zero for keys without expiry
+	TTL(context.Context, string) (time.Duration, bool)
+	Expire(context.Context, string, time.Duration) bool
+	// Incr adds delta to the counter, the ttl is only applied when the counter is created
+	Incr(context.Context, string, int64, time.Duration) (int64, bool)
+	Decr(context.Context, string, int64, time.Duration) (int64, bool)
+	// SetNX sets the value only if the key does not exist, returns false when it already does
+	SetNX(context.Context, string, interface{}, time.Duration) bool
+	// MGet returns the values of the keys found in the cache
+	MGet(context.Context, ...string) (map[string]interface{}, bool)
+	MSet(context.Context, map[string]interface{}, time.Duration) bool
+	// SetWithTags sets the value and associates the key with the tags, see InvalidateTag
+	SetWithTags(context.Context, string, interface{}, time.Duration, ...string) bool
+	// InvalidateTag deletes every key set with any of the tags and returns how many were deleted
+	InvalidateTag(context.Context, ...string) (int, bool)
+}
+
+// CacheV2 surfaces backend failures as errors, a missing key is reported as ErrCacheMiss. A ttl that is
+// not positive keeps the key without expiry on every backend.
+type CacheV2 interface {
+	Set(context.Context, string, interface{}, time.Duration) error
+	Get(context.Context, string) (interface{}, error)
+	GetStruct(context.Context, string, interface{}) error
+	Exists(context.Context, string) (bool, error)
+	Delete(context.Context, ...string) error
+	DeleteByPrefix(context.Context, string) (int, error)
+	TTL(context.Context, string) (time.Duration, error)
+	Expire(context.Context, string, time.Duration) error
+	Incr(context.Context, string, int64, time.Duration) (int64, error)
+	Decr(context.Context, string, int64, time.Duration) (int64, error)
+	SetNX(context.Context, string, interface{}, time.Duration) (bool, error)
+	MGet(context.Context, ...string) (map[string]interface{}, error)
+	MSet(context.Context, map[string]interface{}, time.Duration) error
+	SetWithTags(context.Context, string, interface{}, time.Duration, ...string) error
+	InvalidateTag(context.Context, ...string) (int, error)
+}
+
+// SetKeyNamespace changes the prefix of the keys built by GetKey, call it before the cache is used
+func SetKeyNamespace(opts *KeyNamespaceOpts) {
+	service := opts.Service
+	if service == "" {
+		service = defaultServiceNamespace
+	}
+
+	parts := []string{service}
+	if opts.Env != "" {
+		parts = append(parts, opts.Env)
+	}
+	if opts.Version != "" {
+		parts = append(parts, "v"+opts.Version)
+	}
+	keyPrefix.Store(strings.Join(parts, ":"))
+}
+
+// KeyPrefix returns the namespace of the keys built by GetKey
+func KeyPrefix() string {
+	if prefix, ok := keyPrefix.Load().(string); ok {
+		return prefix
+	}
+	return defaultServiceNamespace
+}
+
 func GetKey(slugs ...string) string {
-	finalKey := serviceNamespace
+	finalKey := KeyPrefix()
 
 	for _, slug := range slugs {
 		finalKey = fmt.Sprintf("%s:%s", finalKey, slug)
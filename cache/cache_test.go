@@ -0,0 +1,31 @@
+package cache
+
+import (
+	"os"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/owlify/sparrow/environment"
+	"github.com/owlify/sparrow/logger"
+	"github.com/owlify/sparrow/sentry"
+)
+
+func TestMain(m *testing.M) {
+	logger.Init(logger.ERROR, environment.TestingEnv)
+	if err := sentry.Init(environment.TestingEnv, ""); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// testRedis points the redis cache to an in-memory redis for the duration of the test
+func testRedis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	server := miniredis.RunT(t)
+	if err := InitRedisCache(&RedisCacheOpts{Host: server.Addr()}); err != nil {
+		t.Fatalf("InitRedisCache: %v", err)
+	}
+	t.Cleanup(func() { CloseRedisCache() })
+	return server
+}
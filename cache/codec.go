@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/owlify/sparrow/errors"
+)
+
+// compressedPrefix marks gzip compressed values, no codec output starts with a NUL byte followed by it
+const compressedPrefix = "\x00gz"
+
+var (
+	ErrValueTooLarge = errors.NewWithCodef("cache_value_too_large", "value exceeds the maximum cache value size")
+)
+
+type Codec interface {
+	Marshal(interface{}) ([]byte, error)
+	Unmarshal([]byte, interface{}) error
+}
+
+var (
+	JSONCodec     Codec = jsonCodec{}
+	MsgpackCodec  Codec = msgpackCodec{}
+	GobCodec      Codec = gobCodec{}
+	ProtobufCodec Codec = protobufCodec{}
+)
+
+// CodecOpts controls how values are serialized before being stored in redis
+type CodecOpts struct {
+	// Codec defaults to JSONCodec
+	Codec Codec
+	// CompressionThreshold gzips encoded values bigger than this many bytes, disabled when zero
+	CompressionThreshold int
+	// MaxValueSize rejects values bigger than this many bytes once encoded with ErrValueTooLarge,
+	// disabled when zero
+	MaxValueSize int
+}
+
+type jsonCodec struct{}
+
+type msgpackCodec struct{}
+
+type gobCodec struct{}
+
+// protobufCodec only accepts values implementing proto.Message
+type protobufCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) { return msgpack.Marshal(v) }
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T does not implement proto.Message", v)
+	}
+	return proto.Marshal(msg)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T does not implement proto.Message", v)
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+func (o *CodecOpts) codec() Codec {
+	if o == nil || o.Codec == nil {
+		return JSONCodec
+	}
+	return o.Codec
+}
+
+// encode serializes the value, compressing it above the threshold and enforcing the size limit
+func (o *CodecOpts) encode(value interface{}) ([]byte, error) {
+	data, err := o.codec().Marshal(value)
+	if err != nil {
+		return nil, wrapErr(ErrSerialization, err)
+	}
+
+	if o != nil && o.CompressionThreshold > 0 && len(data) > o.CompressionThreshold {
+		var buf bytes.Buffer
+		buf.WriteString(compressedPrefix)
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(data); err != nil {
+			return nil, wrapErr(ErrSerialization, err)
+		}
+		if err := writer.Close(); err != nil {
+			return nil, wrapErr(ErrSerialization, err)
+		}
+		data = buf.Bytes()
+	}
+
+	if o != nil && o.MaxValueSize > 0 && len(data) > o.MaxValueSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrValueTooLarge, len(data))
+	}
+
+	return data, nil
+}
+
+// decompress returns the encoded value as produced by the codec
+func (o *CodecOpts) decompress(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(compressedPrefix)) {
+		return data, nil
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(data[len(compressedPrefix):]))
+	if err != nil {
+		return nil, wrapErr(ErrSerialization, err)
+	}
+	defer reader.Close()
+
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, wrapErr(ErrSerialization, err)
+	}
+	return decompressed, nil
+}
+
+func (o *CodecOpts) decode(data []byte, dest interface{}) error {
+	data, err := o.decompress(data)
+	if err != nil {
+		return err
+	}
+
+	if err := o.codec().Unmarshal(data, dest); err != nil {
+		return wrapErr(ErrSerialization, err)
+	}
+	return nil
+}
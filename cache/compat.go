@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+
+	"github.com/owlify/sparrow/errors"
+	"github.com/owlify/sparrow/logger"
+)
+
+// boolCache exposes a CacheV2 through the boolean ExtendedCache API, logging the errors it swallows
+type boolCache struct {
+	store CacheV2
+	name  string
+}
+
+func newBoolCache(store CacheV2, name string) ExtendedCache {
+	return &boolCache{store: store, name: name}
+}
+
+func (c *boolCache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) bool {
+	if err := c.store.Set(ctx, key, value, ttl); err != nil {
+		c.log(ctx, err, "failed to cache the value", logger.Field("key", key), logger.Field("value", value))
+		return false
+	}
+	return true
+}
+
+func (c *boolCache) Get(ctx context.Context, key string) (interface{}, bool) {
+	value, err := c.store.Get(ctx, key)
+	if err != nil {
+		c.log(ctx, err, "error while getting value from cache", logger.Field("key", key))
+		return nil, false
+	}
+	return value, true
+}
+
+func (c *boolCache) GetStruct(ctx context.Context, key string, dest interface{}) bool {
+	if err := c.store.GetStruct(ctx, key, dest); err != nil {
+		c.log(ctx, err, "error while getting struct from cache", logger.Field("key", key))
+		return false
+	}
+	return true
+}
+
+func (c *boolCache) Exists(ctx context.Context, key string) bool {
+	exists, err := c.store.Exists(ctx, key)
+	if err != nil {
+		c.log(ctx, err, "failed while checking key in cache", logger.Field("key", key))
+		return false
+	}
+	return exists
+}
+
+func (c *boolCache) Delete(ctx context.Context, keys ...string) bool {
+	if err := c.store.Delete(ctx, keys...); err != nil {
+		c.log(ctx, err, "failed to delete keys from cache", logger.Field("keys", keys))
+		return false
+	}
+	return true
+}
+
+func (c *boolCache) DeleteByPrefix(ctx context.Context, prefix string) (int, bool) {
+	deleted, err := c.store.DeleteByPrefix(ctx, prefix)
+	if err != nil {
+		c.log(ctx, err, "failed to delete keys by prefix", logger.Field("prefix", prefix))
+		return deleted, false
+	}
+	return deleted, true
+}
+
+func (c *boolCache) TTL(ctx context.Context, key string) (time.Duration, bool) {
+	ttl, err := c.store.TTL(ctx, key)
+	if err != nil {
+		c.log(ctx, err, "failed to get ttl of key", logger.Field("key", key))
+		return 0, false
+	}
+	return ttl, true
+}
+
+func (c *boolCache) Expire(ctx context.Context, key string, ttl time.Duration) bool {
+	if err := c.store.Expire(ctx, key, ttl); err != nil {
+		c.log(ctx, err, "failed to set expiry of key", logger.Field("key", key))
+		return false
+	}
+	return true
+}
+
+func (c *boolCache) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, bool) {
+	value, err := c.store.Incr(ctx, key, delta, ttl)
+	if err != nil {
+		c.log(ctx, err, "failed to increment counter", logger.Field("key", key), logger.Field("delta", delta))
+		return 0, false
+	}
+	return value, true
+}
+
+func (c *boolCache) Decr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, bool) {
+	value, err := c.store.Decr(ctx, key, delta, ttl)
+	if err != nil {
+		c.log(ctx, err, "failed to decrement counter", logger.Field("key", key), logger.Field("delta", delta))
+		return 0, false
+	}
+	return value, true
+}
+
+func (c *boolCache) SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) bool {
+	set, err := c.store.SetNX(ctx, key, value, ttl)
+	if err != nil {
+		c.log(ctx, err, "failed to cache the value if absent", logger.Field("key", key), logger.Field("value", value))
+		return false
+	}
+	return set
+}
+
+func (c *boolCache) MGet(ctx context.Context, keys ...string) (map[string]interface{}, bool) {
+	values, err := c.store.MGet(ctx, keys...)
+	if err != nil {
+		c.log(ctx, err, "error while getting values from cache", logger.Field("keys", keys))
+		return map[string]interface{}{}, false
+	}
+	return values, true
+}
+
+func (c *boolCache) MSet(ctx context.Context, values map[string]interface{}, ttl time.Duration) bool {
+	if err := c.store.MSet(ctx, values, ttl); err != nil {
+		c.log(ctx, err, "failed to cache the values")
+		return false
+	}
+	return true
+}
+
+func (c *boolCache) SetWithTags(ctx context.Context, key string, value interface{}, ttl time.Duration, tags ...string) bool {
+	if err := c.store.SetWithTags(ctx, key, value, ttl, tags...); err != nil {
+		c.log(ctx, err, "failed to cache the tagged value", logger.Field("key", key), logger.Field("tags", tags))
+		return false
+	}
+	return true
+}
+
+func (c *boolCache) InvalidateTag(ctx context.Context, tags ...string) (int, bool) {
+	deleted, err := c.store.InvalidateTag(ctx, tags...)
+	if err != nil {
+		c.log(ctx, err, "failed to invalidate tags", logger.Field("tags", tags))
+		return deleted, false
+	}
+	return deleted, true
+}
+
+// log skips cache misses and dropped writes, which are expected outcomes and not failures
+func (c *boolCache) log(ctx context.Context, err error, message string, fields ...zapcore.Field) {
+	if errors.Is(err, ErrCacheMiss) || errors.Is(err, ErrNotStored) {
+		return
+	}
+	logger.E(ctx, err, fmt.Sprintf("[%s] %s", c.name, message), fields...)
+}
@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"net"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/owlify/sparrow/errors"
+)
+
+var (
+	ErrCacheMiss          = errors.NewWithCodef("cache_miss", "key not found in cache")
+	ErrBackendUnavailable = errors.NewWithCodef("cache_backend_unavailable", "cache backend unavailable")
+	ErrSerialization      = errors.NewWithCodef("cache_serialization", "failed to serialize cached value")
+	ErrNotStored          = errors.NewWithCodef("cache_not_stored", "value was not stored in cache")
+	ErrTimeout            = errors.NewWithCodef("cache_timeout", "cache operation timed out")
+	ErrNotInitialised     = errors.NewWithCodef("cache_not_initialised", "cache is not initialised")
+)
+
+// wrapErr tags err with the sentinel so both errors.Is and the original error stay reachable
+func wrapErr(sentinel error, err error) error {
+	return fmt.Errorf("%w: %w", sentinel, err)
+}
+
+// redisErr maps redigo errors to the cache sentinels, redis reply errors are returned as is.
+// Context deadlines and read or write timeouts become ErrTimeout.
+func redisErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if err == redis.ErrNil {
+		return ErrCacheMiss
+	}
+	if _, ok := err.(redis.Error); ok {
+		return err
+	}
+	if netErr, ok := err.(net.Error); (ok && netErr.Timeout()) || stderrors.Is(err, context.DeadlineExceeded) {
+		return wrapErr(ErrTimeout, err)
+	}
+	return wrapErr(ErrBackendUnavailable, err)
+}
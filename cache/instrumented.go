@@ -0,0 +1,193 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/owlify/sparrow/errors"
+)
+
+// instrumentedCache records hits, misses, errors and latency of the wrapped cache
+type instrumentedCache struct {
+	store   CacheV2
+	backend string
+}
+
+func instrument(store CacheV2, backend string) CacheV2 {
+	return &instrumentedCache{store: store, backend: backend}
+}
+
+func (c *instrumentedCache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	defer c.observe("set", time.Now())
+	err := c.store.Set(ctx, key, value, ttl)
+	c.recordErr("set", key, err)
+	return err
+}
+
+func (c *instrumentedCache) Get(ctx context.Context, key string) (interface{}, error) {
+	defer c.observe("get", time.Now())
+	value, err := c.store.Get(ctx, key)
+	c.recordLookup("get", key, err)
+	return value, err
+}
+
+func (c *instrumentedCache) GetStruct(ctx context.Context, key string, dest interface{}) error {
+	defer c.observe("get_struct", time.Now())
+	err := c.store.GetStruct(ctx, key, dest)
+	c.recordLookup("get_struct", key, err)
+	return err
+}
+
+func (c *instrumentedCache) Exists(ctx context.Context, key string) (bool, error) {
+	defer c.observe("exists", time.Now())
+	exists, err := c.store.Exists(ctx, key)
+	switch {
+	case err != nil:
+		c.recordErr("exists", key, err)
+	case exists:
+		metricsRecorder.Hit(c.backend, keyNamespace(key))
+	default:
+		metricsRecorder.Miss(c.backend, keyNamespace(key))
+	}
+	return exists, err
+}
+
+func (c *instrumentedCache) Delete(ctx context.Context, keys ...string) error {
+	defer c.observe("delete", time.Now())
+	err := c.store.Delete(ctx, keys...)
+	c.recordKeysErr("delete", keys, err)
+	return err
+}
+
+func (c *instrumentedCache) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	defer c.observe("delete_by_prefix", time.Now())
+	deleted, err := c.store.DeleteByPrefix(ctx, prefix)
+	c.recordErr("delete_by_prefix", prefix, err)
+	return deleted, err
+}
+
+func (c *instrumentedCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	defer c.observe("ttl", time.Now())
+	ttl, err := c.store.TTL(ctx, key)
+	c.recordErr("ttl", key, err)
+	return ttl, err
+}
+
+func (c *instrumentedCache) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	defer c.observe("expire", time.Now())
+	err := c.store.Expire(ctx, key, ttl)
+	c.recordErr("expire", key, err)
+	return err
+}
+
+func (c *instrumentedCache) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	defer c.observe("incr", time.Now())
+	value, err := c.store.Incr(ctx, key, delta, ttl)
+	c.recordErr("incr", key, err)
+	return value, err
+}
+
+func (c *instrumentedCache) Decr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	defer c.observe("decr", time.Now())
+	value, err := c.store.Decr(ctx, key, delta, ttl)
+	c.recordErr("decr", key, err)
+	return value, err
+}
+
+func (c *instrumentedCache) SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error) {
+	defer c.observe("set_nx", time.Now())
+	set, err := c.store.SetNX(ctx, key, value, ttl)
+	c.recordErr("set_nx", key, err)
+	return set, err
+}
+
+func (c *instrumentedCache) MGet(ctx context.Context, keys ...string) (map[string]interface{}, error) {
+	defer c.observe("mget", time.Now())
+	values, err := c.store.MGet(ctx, keys...)
+	if err != nil {
+		c.recordKeysErr("mget", keys, err)
+		return values, err
+	}
+
+	for _, key := range keys {
+		if _, found := values[key]; found {
+			metricsRecorder.Hit(c.backend, keyNamespace(key))
+		} else {
+			metricsRecorder.Miss(c.backend, keyNamespace(key))
+		}
+	}
+	return values, nil
+}
+
+func (c *instrumentedCache) MSet(ctx context.Context, values map[string]interface{}, ttl time.Duration) error {
+	defer c.observe("mset", time.Now())
+	err := c.store.MSet(ctx, values, ttl)
+	if err != nil {
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		c.recordKeysErr("mset", keys, err)
+	}
+	return err
+}
+
+func (c *instrumentedCache) SetWithTags(ctx context.Context, key string, value interface{}, ttl time.Duration, tags ...string) error {
+	defer c.observe("set_with_tags", time.Now())
+	err := c.store.SetWithTags(ctx, key, value, ttl, tags...)
+	c.recordErr("set_with_tags", key, err)
+	return err
+}
+
+func (c *instrumentedCache) InvalidateTag(ctx context.Context, tags ...string) (int, error) {
+	defer c.observe("invalidate_tag", time.Now())
+	deleted, err := c.store.InvalidateTag(ctx, tags...)
+	if err != nil {
+		keys := make([]string, 0, len(tags))
+		for _, tag := range tags {
+			keys = append(keys, tagKey(tag))
+		}
+		c.recordKeysErr("invalidate_tag", keys, err)
+	}
+	return deleted, err
+}
+
+func (c *instrumentedCache) observe(op string, start time.Time) {
+	metricsRecorder.Latency(c.backend, op, time.Since(start))
+}
+
+func (c *instrumentedCache) recordLookup(op string, key string, err error) {
+	switch {
+	case err == nil:
+		metricsRecorder.Hit(c.backend, keyNamespace(key))
+	case errors.Is(err, ErrCacheMiss):
+		metricsRecorder.Miss(c.backend, keyNamespace(key))
+	default:
+		c.recordErr(op, key, err)
+	}
+}
+
+// recordErr counts backend failures, misses and dropped ristretto writes are not errors
+func (c *instrumentedCache) recordErr(op string, key string, err error) {
+	if err == nil || errors.Is(err, ErrCacheMiss) || errors.Is(err, ErrNotStored) {
+		return
+	}
+	metricsRecorder.Error(c.backend, keyNamespace(key), op)
+}
+
+// recordKeysErr counts a failed multi key operation once for every namespace of its keys
+func (c *instrumentedCache) recordKeysErr(op string, keys []string, err error) {
+	if err == nil || errors.Is(err, ErrCacheMiss) || errors.Is(err, ErrNotStored) {
+		return
+	}
+
+	namespaces := map[string]struct{}{}
+	for _, key := range keys {
+		namespace := keyNamespace(key)
+		if _, recorded := namespaces[namespace]; recorded {
+			continue
+		}
+		namespaces[namespace] = struct{}{}
+		metricsRecorder.Error(c.backend, namespace, op)
+	}
+}
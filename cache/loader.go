@@ -0,0 +1,262 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/owlify/sparrow/errors"
+	"github.com/owlify/sparrow/logger"
+)
+
+var (
+	// ErrNotFound is returned by a LoaderFunc when the value does not exist, enabling negative caching
+	ErrNotFound = errors.NewWithCodef("cache_not_found", "value not found")
+)
+
+const defaultLoadTimeout = 30 * time.Second
+
+type LoaderFunc func(ctx context.Context) (interface{}, error)
+
+// LoaderOpts default to their zero values when nil
+type LoaderOpts struct {
+	// TTL keeps the loaded values without expiry when not positive, as the CacheV2 backends do
+	TTL time.Duration
+	// NegativeTTL caches ErrNotFound results of the loader, disabled when zero
+	NegativeTTL time.Duration
+	// EarlyRefreshBeta refreshes values probabilistically before they expire, disabled when zero.
+	// 1 is a sensible default, higher values refresh earlier.
+	EarlyRefreshBeta float64
+	// StaleTTL keeps serving an expired value for this long while it is refreshed in the background
+	StaleTTL time.Duration
+	// LoadTimeout bounds a load shared by concurrent callers, which outlives the caller that started it.
+	// Defaults to 30 seconds.
+	LoadTimeout time.Duration
+}
+
+type Loader struct {
+	store CacheV2
+	group *flightGroup
+}
+
+// loadedValue is the envelope stored in the cache by the loader
+type loadedValue struct {
+	Value    json.RawMessage `json:"value,omitempty"`
+	NotFound bool            `json:"not_found,omitempty"`
+	// ExpiresAt is the logical expiry in unix milliseconds, the key itself lives StaleTTL longer.
+	// Zero when the value does not expire.
+	ExpiresAt int64 `json:"expires_at"`
+	// LoadTime is how long the loader took in milliseconds, used to refresh early
+	LoadTime int64 `json:"load_time"`
+}
+
+// flightGroup coalesces concurrent loads of the same key into one call
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done  chan struct{}
+	value *loadedValue
+	err   error
+}
+
+func NewLoader(store CacheV2) *Loader {
+	return &Loader{
+		store: store,
+		group: &flightGroup{calls: map[string]*flightCall{}},
+	}
+}
+
+// GetOrLoad reads the key into dest, calling load on a miss and caching its result.
+// Concurrent misses of the same key share a single load. When the cache backend fails
+// the value is loaded without being cached.
+func (l *Loader) GetOrLoad(ctx context.Context, key string, dest interface{}, opts *LoaderOpts, load LoaderFunc) error {
+	if opts == nil {
+		opts = &LoaderOpts{}
+	}
+
+	cached := &loadedValue{}
+	err := l.store.GetStruct(ctx, key, cached)
+	if err == nil {
+		err = l.serveCached(ctx, key, cached, dest, opts, load)
+		if !errors.Is(err, ErrSerialization) {
+			return err
+		}
+	}
+
+	switch {
+	case errors.Is(err, ErrCacheMiss), errors.Is(err, ErrSerialization):
+		// a value that no longer decodes, e.g. after its type changed, is reloaded and overwritten
+		value, err := l.group.do(ctx, key, func() (*loadedValue, error) {
+			return l.sharedLoad(ctx, key, opts, load)
+		})
+		if err != nil {
+			return err
+		}
+		return value.decode(dest)
+	default:
+		logger.W(ctx, "[CacheLoader] cache unavailable, loading without cache",
+			logger.Field("key", key),
+			logger.Field("error", err.Error()),
+		)
+		value, err := l.load(ctx, "", opts, load)
+		if err != nil {
+			return err
+		}
+		return value.decode(dest)
+	}
+}
+
+// serveCached decodes the cached value, refreshing it in the background when it is due
+func (l *Loader) serveCached(ctx context.Context, key string, cached *loadedValue, dest interface{}, opts *LoaderOpts, load LoaderFunc) error {
+	if err := cached.decode(dest); err != nil {
+		return err
+	}
+
+	if cached.ExpiresAt != 0 {
+		now := time.Now()
+		expiresAt := time.UnixMilli(cached.ExpiresAt)
+		if now.After(expiresAt) || shouldRefreshEarly(now, expiresAt, cached.LoadTime, opts.EarlyRefreshBeta) {
+			go l.refresh(context.WithoutCancel(ctx), key, opts, load)
+		}
+	}
+	return nil
+}
+
+// sharedLoad detaches the load from the caller that started it, the other callers waiting on it
+// would otherwise fail with its cancellation
+func (l *Loader) sharedLoad(ctx context.Context, key string, opts *LoaderOpts, load LoaderFunc) (*loadedValue, error) {
+	timeout := opts.LoadTimeout
+	if timeout <= 0 {
+		timeout = defaultLoadTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+	return l.load(ctx, key, opts, load)
+}
+
+func (l *Loader) refresh(ctx context.Context, key string, opts *LoaderOpts, load LoaderFunc) {
+	if l.group.inFlight(key) {
+		return
+	}
+
+	_, err := l.group.do(ctx, key, func() (*loadedValue, error) {
+		return l.sharedLoad(ctx, key, opts, load)
+	})
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		logger.E(ctx, err, "[CacheLoader] failed to refresh cached value", logger.Field("key", key))
+	}
+}
+
+// load calls the loader and caches its result, nothing is cached when key is empty
+func (l *Loader) load(ctx context.Context, key string, opts *LoaderOpts, load LoaderFunc) (*loadedValue, error) {
+	start := time.Now()
+	result, err := load(ctx)
+	loadTime := time.Since(start)
+
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+
+		value := &loadedValue{NotFound: true, ExpiresAt: time.Now().Add(opts.NegativeTTL).UnixMilli()}
+		if key != "" && opts.NegativeTTL > 0 {
+			if err := l.store.Set(ctx, key, value, opts.NegativeTTL); err != nil {
+				logger.W(ctx, "[CacheLoader] failed to cache missing value",
+					logger.Field("key", key),
+					logger.Field("error", err.Error()),
+				)
+			}
+		}
+		return value, nil
+	}
+
+	bytes, err := json.Marshal(result)
+	if err != nil {
+		return nil, wrapErr(ErrSerialization, err)
+	}
+
+	value := &loadedValue{
+		Value:    bytes,
+		LoadTime: loadTime.Milliseconds(),
+	}
+	storeTTL := time.Duration(0)
+	if opts.TTL > 0 {
+		value.ExpiresAt = time.Now().Add(opts.TTL).UnixMilli()
+		storeTTL = opts.TTL + opts.StaleTTL
+	}
+	if key != "" {
+		if err := l.store.Set(ctx, key, value, storeTTL); err != nil {
+			logger.W(ctx, "[CacheLoader] failed to cache loaded value",
+				logger.Field("key", key),
+				logger.Field("error", err.Error()),
+			)
+		}
+	}
+	return value, nil
+}
+
+func (v *loadedValue) decode(dest interface{}) error {
+	if v.NotFound {
+		return ErrNotFound
+	}
+	if err := json.Unmarshal(v.Value, dest); err != nil {
+		return wrapErr(ErrSerialization, err)
+	}
+	return nil
+}
+
+// shouldRefreshEarly implements probabilistic early expiration (XFetch): the closer the
+// expiry and the slower the load, the likelier a refresh
+func shouldRefreshEarly(now time.Time, expiresAt time.Time, loadTime int64, beta float64) bool {
+	if beta <= 0 {
+		return false
+	}
+
+	gap := time.Duration(float64(loadTime)*beta*-math.Log(1-rand.Float64())) * time.Millisecond
+	return !now.Add(gap).Before(expiresAt)
+}
+
+// do runs fn once for concurrent callers of the key, a caller stops waiting when its ctx is done
+func (g *flightGroup) do(ctx context.Context, key string, fn func() (*loadedValue, error)) (*loadedValue, error) {
+	g.mu.Lock()
+	call, ok := g.calls[key]
+	if !ok {
+		call = &flightCall{done: make(chan struct{})}
+		g.calls[key] = call
+		go g.run(key, call, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (g *flightGroup) run(key string, call *flightCall, fn func() (*loadedValue, error)) {
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.value, call.err = fn()
+}
+
+func (g *flightGroup) inFlight(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	_, ok := g.calls[key]
+	return ok
+}
@@ -0,0 +1,229 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/google/uuid"
+
+	"github.com/owlify/sparrow/errors"
+	"github.com/owlify/sparrow/logger"
+)
+
+const (
+	defaultLockTTL           = 30 * time.Second
+	defaultLockRetryInterval = 100 * time.Millisecond
+	// minLockTTL keeps the heartbeat interval, a third of the ttl, above zero
+	minLockTTL = 10 * time.Millisecond
+)
+
+var (
+	ErrLockNotAcquired = errors.NewWithCodef("lock_not_acquired", "lock is held by someone else")
+	ErrLockNotHeld     = errors.NewWithCodef("lock_not_held", "lock is no longer held")
+	ErrInvalidLockTTL  = errors.NewWithCodef("lock_invalid_ttl", "lock ttl must be at least a millisecond")
+)
+
+// acquireLockScript takes the lock and bumps its fencing counter, returns 0 when the lock is taken
+var acquireLockScript = redis.NewScript(2, `
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return redis.call('INCR', KEYS[2])
+end
+return 0
+`)
+
+var releaseLockScript = redis.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+var extendLockScript = redis.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+type LockOpts struct {
+	// TTL is how long the lock is held unless extended, defaults to 30 seconds and is raised to
+	// 10 milliseconds when shorter
+	TTL time.Duration
+	// RetryInterval is the wait between acquire attempts, defaults to 100 milliseconds
+	RetryInterval time.Duration
+	// WaitTimeout bounds how long Acquire retries, it gives up after a single attempt when zero
+	// unless the context carries a deadline
+	WaitTimeout time.Duration
+}
+
+type Locker struct {
+	opts *LockOpts
+}
+
+type Lock struct {
+	client *redisClient
+	key    string
+	token  string
+	fence  int64
+	ttl    time.Duration
+}
+
+// NewLocker builds locks on top of the redis cache, which is looked up on every Acquire so the
+// locker can be created before InitRedisCache
+func NewLocker(opts *LockOpts) *Locker {
+	lockOpts := &LockOpts{}
+	if opts != nil {
+		*lockOpts = *opts
+	}
+	if lockOpts.TTL <= 0 {
+		lockOpts.TTL = defaultLockTTL
+	}
+	if lockOpts.TTL < minLockTTL {
+		lockOpts.TTL = minLockTTL
+	}
+	if lockOpts.RetryInterval <= 0 {
+		lockOpts.RetryInterval = defaultLockRetryInterval
+	}
+
+	return &Locker{opts: lockOpts}
+}
+
+// Acquire takes the lock, retrying until WaitTimeout elapses or the context is done
+func (l *Locker) Acquire(ctx context.Context, key string) (*Lock, error) {
+	if l.opts.WaitTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.opts.WaitTimeout)
+		defer cancel()
+	}
+
+	_, hasDeadline := ctx.Deadline()
+	for {
+		lock, err := l.tryAcquire(ctx, key)
+		if err == nil || !errors.Is(err, ErrLockNotAcquired) || !hasDeadline {
+			return lock, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ErrLockNotAcquired
+		case <-time.After(l.opts.RetryInterval):
+		}
+	}
+}
+
+// WithLock runs fn while holding the lock, extending it in the background until fn returns.
+// The context given to fn is cancelled if the lock is lost.
+func (l *Locker) WithLock(ctx context.Context, key string, fn func(context.Context) error) error {
+	lock, err := l.Acquire(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	fnCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan struct{})
+	go lock.heartbeat(fnCtx, cancel, done)
+
+	err = fn(fnCtx)
+	close(done)
+
+	if releaseErr := lock.Release(context.WithoutCancel(ctx)); releaseErr != nil && err == nil {
+		return releaseErr
+	}
+	return err
+}
+
+func (l *Locker) tryAcquire(ctx context.Context, key string) (*Lock, error) {
+	client := redisCacheClient
+	if client == nil {
+		return nil, ErrNotInitialised
+	}
+
+	token := uuid.NewString()
+	fence, err := redis.Int64(client.eval(ctx, acquireLockScript, key, fenceKey(key), token, l.opts.TTL.Milliseconds()))
+	if err != nil {
+		return nil, redisErr(err)
+	}
+	if fence == 0 {
+		return nil, ErrLockNotAcquired
+	}
+
+	return &Lock{
+		client: client,
+		key:    key,
+		token:  token,
+		fence:  fence,
+		ttl:    l.opts.TTL,
+	}, nil
+}
+
+func (l *Lock) Key() string {
+	return l.key
+}
+
+func (l *Lock) Token() string {
+	return l.token
+}
+
+// FencingToken increases every time the lock is acquired, storage writes guarded by the lock
+// should reject tokens older than the last one they have seen
+func (l *Lock) FencingToken() int64 {
+	return l.fence
+}
+
+// Extend resets the lock expiry to ttl, fails with ErrLockNotHeld if the lock expired meanwhile.
+// A ttl under a millisecond is rejected with ErrInvalidLockTTL, redis would delete the lock.
+func (l *Lock) Extend(ctx context.Context, ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrInvalidLockTTL
+	}
+
+	extended, err := redis.Int(l.client.eval(ctx, extendLockScript, l.key, l.token, ttl.Milliseconds()))
+	if err != nil {
+		return redisErr(err)
+	}
+	if extended == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Release frees the lock only if it is still held with this lock's token
+func (l *Lock) Release(ctx context.Context) error {
+	released, err := redis.Int(l.client.eval(ctx, releaseLockScript, l.key, l.token))
+	if err != nil {
+		return redisErr(err)
+	}
+	if released == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+func (l *Lock) heartbeat(ctx context.Context, cancel context.CancelFunc, done <-chan struct{}) {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.Extend(ctx, l.ttl); err != nil {
+				logger.E(ctx, err, "[Lock] failed to extend lock", logger.Field("key", l.key))
+				if errors.Is(err, ErrLockNotHeld) {
+					cancel()
+					return
+				}
+			}
+		}
+	}
+}
+
+func fenceKey(key string) string {
+	return key + ":fence"
+}
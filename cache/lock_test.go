@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/owlify/sparrow/errors"
+)
+
+func testLocker(t *testing.T, opts *LockOpts) (*Locker, *miniredis.Miniredis) {
+	t.Helper()
+	server := testRedis(t)
+	return NewLocker(opts), server
+}
+
+func lockKey(t *testing.T) string {
+	return "sparrow-test:lock:" + t.Name() + ":" + time.Now().Format(time.RFC3339Nano)
+}
+
+func TestLockIsExclusive(t *testing.T) {
+	locker, _ := testLocker(t, &LockOpts{TTL: time.Minute})
+	ctx := context.Background()
+	key := lockKey(t)
+
+	lock, err := locker.Acquire(ctx, key)
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	defer lock.Release(ctx)
+
+	if _, err := locker.Acquire(ctx, key); !errors.Is(err, ErrLockNotAcquired) {
+		t.Errorf("second Acquire error = %v, want ErrLockNotAcquired", err)
+	}
+}
+
+func TestLockReleaseChecksOwnership(t *testing.T) {
+	locker, _ := testLocker(t, &LockOpts{TTL: time.Minute})
+	ctx := context.Background()
+	key := lockKey(t)
+
+	lock, err := locker.Acquire(ctx, key)
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	foreign := &Lock{client: lock.client, key: key, token: "someone-else"}
+
+	if err := foreign.Release(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("foreign Release error = %v, want ErrLockNotHeld", err)
+	}
+	if err := foreign.Extend(ctx, time.Hour); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("foreign Extend error = %v, want ErrLockNotHeld", err)
+	}
+	// the owner still holds the lock
+	if _, err := locker.Acquire(ctx, key); !errors.Is(err, ErrLockNotAcquired) {
+		t.Errorf("Acquire after a foreign Release error = %v, want ErrLockNotAcquired", err)
+	}
+
+	if err := lock.Extend(ctx, time.Minute); err != nil {
+		t.Errorf("Extend: %v", err)
+	}
+	if err := lock.Release(ctx); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if err := lock.Release(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("second Release error = %v, want ErrLockNotHeld", err)
+	}
+}
+
+func TestLockExpiredIsNotReleasedByItsOldOwner(t *testing.T) {
+	locker, server := testLocker(t, &LockOpts{TTL: 50 * time.Millisecond})
+	ctx := context.Background()
+	key := lockKey(t)
+
+	first, err := locker.Acquire(ctx, key)
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	server.FastForward(100 * time.Millisecond)
+
+	second, err := NewLocker(&LockOpts{TTL: time.Minute}).Acquire(ctx, key)
+	if err != nil {
+		t.Fatalf("Acquire after expiry: %v", err)
+	}
+	defer second.Release(ctx)
+
+	if second.FencingToken() <= first.FencingToken() {
+		t.Errorf("fencing token %d did not increase from %d", second.FencingToken(), first.FencingToken())
+	}
+	if err := first.Release(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expired Release error = %v, want ErrLockNotHeld", err)
+	}
+	if err := first.Extend(ctx, time.Minute); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expired Extend error = %v, want ErrLockNotHeld", err)
+	}
+}
+
+func TestLockerBeforeInit(t *testing.T) {
+	redisCacheClient = nil
+	locker := NewLocker(nil)
+	if _, err := locker.Acquire(context.Background(), "sparrow-test:lock"); !errors.Is(err, ErrNotInitialised) {
+		t.Fatalf("Acquire error = %v, want ErrNotInitialised", err)
+	}
+
+	testRedis(t)
+	lock, err := locker.Acquire(context.Background(), "sparrow-test:lock")
+	if err != nil {
+		t.Fatalf("Acquire after InitRedisCache: %v", err)
+	}
+	lock.Release(context.Background())
+}
+
+func TestWithLockShortTTL(t *testing.T) {
+	locker, _ := testLocker(t, &LockOpts{TTL: time.Nanosecond})
+	ctx := context.Background()
+
+	err := locker.WithLock(ctx, lockKey(t), func(ctx context.Context) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("WithLock: %v", err)
+	}
+}
+
+func TestExtendRejectsSubMillisecondTTL(t *testing.T) {
+	locker, _ := testLocker(t, nil)
+	ctx := context.Background()
+
+	lock, err := locker.Acquire(ctx, lockKey(t))
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	defer lock.Release(ctx)
+
+	if err := lock.Extend(ctx, time.Microsecond); !errors.Is(err, ErrInvalidLockTTL) {
+		t.Errorf("Extend error = %v, want ErrInvalidLockTTL", err)
+	}
+}
@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the latency histogram, the last bucket is unbounded
+var latencyBuckets = []time.Duration{
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// MetricsRecorder receives the cache events, implement it to forward them to a metrics system
+type MetricsRecorder interface {
+	Hit(backend string, namespace string)
+	Miss(backend string, namespace string)
+	Error(backend string, namespace string, op string)
+	Latency(backend string, op string, duration time.Duration)
+}
+
+type NamespaceStats struct {
+	Backend   string `json:"backend"`
+	Namespace string `json:"namespace"`
+	Hits      int64  `json:"hits"`
+	Misses    int64  `json:"misses"`
+	Errors    int64  `json:"errors"`
+}
+
+type LatencyStats struct {
+	Backend string        `json:"backend"`
+	Op      string        `json:"op"`
+	Count   int64         `json:"count"`
+	Sum     time.Duration `json:"sum"`
+	// Buckets counts the calls at or below each bound of LatencyBuckets, not cumulative
+	Buckets []int64 `json:"buckets"`
+}
+
+type MetricsSnapshot struct {
+	Namespaces     []NamespaceStats `json:"namespaces"`
+	Latencies      []LatencyStats   `json:"latencies"`
+	LatencyBuckets []time.Duration  `json:"latency_buckets"`
+}
+
+// memoryMetrics is the default recorder, it keeps the counters in process
+type memoryMetrics struct {
+	mu         sync.Mutex
+	namespaces map[[2]string]*NamespaceStats
+	latencies  map[[2]string]*LatencyStats
+}
+
+var metricsRecorder MetricsRecorder = newMemoryMetrics()
+
+func newMemoryMetrics() *memoryMetrics {
+	return &memoryMetrics{
+		namespaces: map[[2]string]*NamespaceStats{},
+		latencies:  map[[2]string]*LatencyStats{},
+	}
+}
+
+// SetMetricsRecorder replaces the in process recorder, call it before the caches are created
+func SetMetricsRecorder(recorder MetricsRecorder) {
+	metricsRecorder = recorder
+}
+
+// Metrics returns the counters of the in process recorder, empty when it was replaced
+func Metrics() MetricsSnapshot {
+	recorder, ok := metricsRecorder.(*memoryMetrics)
+	if !ok {
+		return MetricsSnapshot{LatencyBuckets: latencyBuckets}
+	}
+	return recorder.snapshot()
+}
+
+func (m *memoryMetrics) Hit(backend string, namespace string) {
+	m.mu.Lock()
+	m.namespace(backend, namespace).Hits++
+	m.mu.Unlock()
+}
+
+func (m *memoryMetrics) Miss(backend string, namespace string) {
+	m.mu.Lock()
+	m.namespace(backend, namespace).Misses++
+	m.mu.Unlock()
+}
+
+func (m *memoryMetrics) Error(backend string, namespace string, op string) {
+	m.mu.Lock()
+	m.namespace(backend, namespace).Errors++
+	m.mu.Unlock()
+}
+
+func (m *memoryMetrics) Latency(backend string, op string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats, ok := m.latencies[[2]string{backend, op}]
+	if !ok {
+		stats = &LatencyStats{Backend: backend, Op: op, Buckets: make([]int64, len(latencyBuckets)+1)}
+		m.latencies[[2]string{backend, op}] = stats
+	}
+
+	stats.Count++
+	stats.Sum += duration
+	stats.Buckets[sort.Search(len(latencyBuckets), func(i int) bool { return duration <= latencyBuckets[i] })]++
+}
+
+func (m *memoryMetrics) namespace(backend string, namespace string) *NamespaceStats {
+	stats, ok := m.namespaces[[2]string{backend, namespace}]
+	if !ok {
+		stats = &NamespaceStats{Backend: backend, Namespace: namespace}
+		m.namespaces[[2]string{backend, namespace}] = stats
+	}
+	return stats
+}
+
+func (m *memoryMetrics) snapshot() MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := MetricsSnapshot{LatencyBuckets: latencyBuckets}
+	for _, stats := range m.namespaces {
+		snapshot.Namespaces = append(snapshot.Namespaces, *stats)
+	}
+	for _, stats := range m.latencies {
+		latency := *stats
+		latency.Buckets = append([]int64(nil), stats.Buckets...)
+		snapshot.Latencies = append(snapshot.Latencies, latency)
+	}
+
+	sort.Slice(snapshot.Namespaces, func(i, j int) bool {
+		a, b := snapshot.Namespaces[i], snapshot.Namespaces[j]
+		return a.Backend+":"+a.Namespace < b.Backend+":"+b.Namespace
+	})
+	sort.Slice(snapshot.Latencies, func(i, j int) bool {
+		a, b := snapshot.Latencies[i], snapshot.Latencies[j]
+		return a.Backend+":"+a.Op < b.Backend+":"+b.Op
+	})
+	return snapshot
+}
+
+// keyNamespace is the first slug given to GetKey, keys not built by it are grouped by their first segment
+func keyNamespace(key string) string {
+	if rest, found := strings.CutPrefix(key, KeyPrefix()+":"); found {
+		key = rest
+	}
+	namespace, _, _ := strings.Cut(key, ":")
+	return namespace
+}
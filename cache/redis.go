@@ -3,18 +3,21 @@ package cache
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
-	"github.com/owlify/sparrow/logger"
+	"github.com/newrelic/go-agent/v3/newrelic"
+
+	"github.com/owlify/sparrow/errors"
+	"github.com/owlify/sparrow/redisconn"
 )
 
 type redisClient struct {
-	pool *redis.Pool
+	pool  *redis.Pool
+	codec *CodecOpts
 }
 
 type RedisCacheOpts struct {
@@ -27,167 +30,630 @@ type RedisCacheOpts struct {
 	MaxActiveConnection   int
 	IdleConnectionTimeout time.Duration
 	MaxConnectionLifetime time.Duration
+
+	// ReadTimeout and WriteTimeout bound every command on top of the context deadline, disabled when zero
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// UseTLS enables TLS verified against the system roots, implied when CertPath is set
+	UseTLS bool
+	// DialRetries is how many times a failed dial is retried, defaults to 2, negative disables retries
+	DialRetries int
+	// DialBackoff is the wait before the first retry, doubled on every retry, defaults to 50 milliseconds
+	DialBackoff time.Duration
+
+	// Connection takes precedence over DB, Host, Password, Username, CertPath, UseTLS and the timeouts when
+	// set, and its PoolSize over MaxActiveConnection. Redis Cluster is rejected with ErrClusterNotSupported.
+	Connection *redisconn.Opts
+
+	// KeyNamespace sets the prefix of the keys built by GetKey, see SetKeyNamespace
+	KeyNamespace *KeyNamespaceOpts
+
+	// Codec serializes the values, defaults to JSONCodec
+	Codec Codec
+	// CompressionThreshold gzips values bigger than this many bytes once encoded, disabled when zero
+	CompressionThreshold int
+	// MaxValueSize makes writes of bigger encoded values fail with ErrValueTooLarge, disabled when zero
+	MaxValueSize int
+}
+
+type PoolStats struct {
+	ActiveCount  int           `json:"active_count"`
+	IdleCount    int           `json:"idle_count"`
+	WaitCount    int64         `json:"wait_count"`
+	WaitDuration time.Duration `json:"wait_duration"`
 }
 
+const (
+	defaultDialRetries = 2
+	defaultDialBackoff = 50 * time.Millisecond
+	maxDialBackoff     = time.Second
+)
+
+// ErrClusterNotSupported is returned by InitRedisCache for a Redis Cluster connection, the cache scripts
+// and multi-key commands need every key on the same node
+var ErrClusterNotSupported = errors.NewWithCodef("redis_cluster_not_supported", "redis cluster is not supported by the redis cache")
+
 var redisCacheClient *redisClient
 
-func NewRedisCache() Cache {
-	return redisCacheClient
+func NewRedisCache() ExtendedCache {
+	return newBoolCache(NewRedisCacheV2(), "RedisCache")
 }
 
-func InitRedisCache(opts *RedisCacheOpts) {
-	pool := initRedisPool(opts)
+func NewRedisCacheV2() CacheV2 {
+	return instrument(redisCacheClient, "redis")
+}
+
+// InitRedisCache sets up the redis cache, which is only set once redis answers a PING
+func InitRedisCache(opts *RedisCacheOpts) error {
+	pool, err := initRedisPool(opts)
+	if err != nil {
+		return err
+	}
+	if opts.KeyNamespace != nil {
+		SetKeyNamespace(opts.KeyNamespace)
+	}
 
-	redisCacheClient = &redisClient{
+	client := &redisClient{
 		pool: pool,
+		codec: &CodecOpts{
+			Codec:                opts.Codec,
+			CompressionThreshold: opts.CompressionThreshold,
+			MaxValueSize:         opts.MaxValueSize,
+		},
 	}
 
-	conn := pool.Get()
+	if err := client.ping(context.Background()); err != nil {
+		pool.Close()
+		return fmt.Errorf("failed to connect redis: %w", err)
+	}
+	redisCacheClient = client
+	return nil
+}
 
-	defer conn.Close()
+func initRedisPool(opts *RedisCacheOpts) (*redis.Pool, error) {
+	conn := connectionOpts(opts)
+	if err := conn.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid redis connection config: %w", err)
+	}
+	if conn.IsCluster() {
+		return nil, ErrClusterNotSupported
+	}
 
-	_, err := redis.String(conn.Do("PING"))
+	tlsConfig, err := conn.TLSConfig()
 	if err != nil {
-		panic(fmt.Sprintf("failed to connect redis %v", err.Error()))
+		return nil, err
 	}
-}
 
-func initRedisPool(opts *RedisCacheOpts) *redis.Pool {
-	var tlsConfig *tls.Config
-	if opts.CertPath != "" {
-		caCert, err := ioutil.ReadFile(opts.CertPath)
-		if err != nil {
-			panic(fmt.Sprintf("Failed to read CA certificate: %v", err))
-		}
-
-		// Create a new certificate pool and add the CA cert
-		caCertPool := x509.NewCertPool()
-		if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
-			panic("Failed to append CA certificate")
-		}
+	dialRetries := opts.DialRetries
+	if dialRetries == 0 {
+		dialRetries = defaultDialRetries
+	} else if dialRetries < 0 {
+		// negative disables the retries, the connection is still dialed once
+		dialRetries = 0
+	}
+	dialBackoff := opts.DialBackoff
+	if dialBackoff <= 0 {
+		dialBackoff = defaultDialBackoff
+	}
 
-		// Create a TLS configuration using the CA cert
-		tlsConfig = &tls.Config{
-			MinVersion:         tls.VersionTLS12,
-			RootCAs:            caCertPool,
-			InsecureSkipVerify: true,
-		}
+	maxActive := opts.MaxActiveConnection
+	if conn.PoolSize > 0 {
+		maxActive = conn.PoolSize
 	}
 
 	return &redis.Pool{
 		MaxIdle:         opts.MaxIdleConnection,
-		MaxActive:       opts.MaxActiveConnection,
+		MaxActive:       maxActive,
 		IdleTimeout:     opts.IdleConnectionTimeout, // Setting timeout so that the workers are not blocked
 		MaxConnLifetime: opts.MaxConnectionLifetime,
-		Dial: func() (redis.Conn, error) {
-			passwordOption := redis.DialPassword(opts.Password)
-			usernameOption := redis.DialUsername(opts.Username)
-			dbOption := redis.DialDatabase(opts.DB)
-			tlsOption := redis.DialTLSConfig(tlsConfig)
-			c, dialErr := redis.Dial("tcp", opts.Host, usernameOption, passwordOption, dbOption, tlsOption, redis.DialUseTLS(true))
-			if dialErr != nil {
-				panic(fmt.Sprintf("dial error: %s", dialErr.Error()))
-			}
-			return c, dialErr
+		DialContext: func(ctx context.Context) (redis.Conn, error) {
+			return dialWithBackoff(ctx, conn, tlsConfig, dialRetries, dialBackoff)
 		},
 		TestOnBorrow: func(c redis.Conn, t time.Time) error {
 			_, err := c.Do("PING")
 			return err
 		},
+	}, nil
+}
+
+// dialWithBackoff retries failed dials, doubling the wait after every attempt, until ctx is done
+func dialWithBackoff(ctx context.Context, conn *redisconn.Opts, tlsConfig *tls.Config, retries int, backoff time.Duration) (redis.Conn, error) {
+	var lastErr error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, fmt.Errorf("dial error: %w", ctx.Err())
+			case <-timer.C:
+			}
+			if backoff *= 2; backoff > maxDialBackoff {
+				backoff = maxDialBackoff
+			}
+		}
+
+		addr, err := redisAddr(ctx, conn)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		c, err := redis.DialContext(ctx, "tcp", addr, dialOptions(conn, tlsConfig)...)
+		if err == nil {
+			return c, nil
+		}
+		lastErr = err
 	}
+
+	return nil, fmt.Errorf("dial error: %w", lastErr)
 }
 
-// NOTE: Please don't put huge json into the case
-// TODO: Add safe guard to checkout the value to be cached, if bigger than threshold should raise exception
-func (c *redisClient) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) bool {
-	conn := c.pool.Get()
-	defer conn.Close()
+// connectionOpts returns the shared connection config, built from the flat fields when not given
+func connectionOpts(opts *RedisCacheOpts) *redisconn.Opts {
+	if opts.Connection != nil {
+		return opts.Connection
+	}
+
+	conn := &redisconn.Opts{
+		Addr:     opts.Host,
+		Username: opts.Username,
+		Password: opts.Password,
+		DB:       opts.DB,
+
+		ReadTimeout:  opts.ReadTimeout,
+		WriteTimeout: opts.WriteTimeout,
+	}
+	if opts.CertPath != "" || opts.UseTLS {
+		// the server certificate is verified against the CertPath bundle, or the system roots without it
+		conn.TLS = &redisconn.TLSOpts{CertPath: opts.CertPath}
+	}
+	return conn
+}
 
-	val, _ := json.Marshal(value)
+func dialOptions(conn *redisconn.Opts, tlsConfig *tls.Config) []redis.DialOption {
+	dialOpts := []redis.DialOption{
+		redis.DialUsername(conn.Username),
+		redis.DialPassword(conn.Password),
+		redis.DialDatabase(conn.DB),
+	}
 
-	_, err := redis.String(
-		conn.Do(
-			"SETEX",
-			key,
-			int(ttl.Seconds()),
-			string(val),
-		),
-	)
+	if tlsConfig != nil {
+		dialOpts = append(dialOpts, redis.DialUseTLS(true), redis.DialTLSConfig(tlsConfig))
+	}
+	if conn.DialTimeout > 0 {
+		dialOpts = append(dialOpts, redis.DialConnectTimeout(conn.DialTimeout))
+	}
+	if conn.ReadTimeout > 0 {
+		dialOpts = append(dialOpts, redis.DialReadTimeout(conn.ReadTimeout))
+	}
+	if conn.WriteTimeout > 0 {
+		dialOpts = append(dialOpts, redis.DialWriteTimeout(conn.WriteTimeout))
+	}
 
+	return dialOpts
+}
+
+// redisAddr resolves the address to dial, asking the sentinels for the current master in failover mode
+func redisAddr(ctx context.Context, conn *redisconn.Opts) (string, error) {
+	if !conn.IsSentinel() {
+		return conn.Addr, nil
+	}
+
+	var lastErr error
+	for _, sentinelAddr := range conn.Sentinel.Addrs {
+		addr, err := sentinelMasterAddr(ctx, conn, sentinelAddr)
+		if err == nil {
+			return addr, nil
+		}
+		lastErr = err
+	}
+
+	return "", fmt.Errorf("failed to resolve redis master %s: %w", conn.Sentinel.MasterName, lastErr)
+}
+
+func sentinelMasterAddr(ctx context.Context, conn *redisconn.Opts, sentinelAddr string) (string, error) {
+	dialOpts := []redis.DialOption{redis.DialPassword(conn.Sentinel.Password)}
+	if conn.DialTimeout > 0 {
+		dialOpts = append(dialOpts, redis.DialConnectTimeout(conn.DialTimeout))
+	}
+
+	c, err := redis.DialContext(ctx, "tcp", sentinelAddr, dialOpts...)
 	if err != nil {
-		logger.E(ctx, err, "[RedisCache] failed to cache the value",
-			logger.Field("key", key),
-			logger.Field("value", value),
-		)
-		return false
+		return "", err
 	}
+	defer c.Close()
 
-	return true
+	master, err := redis.Strings(redis.DoContext(c, ctx, "SENTINEL", "get-master-addr-by-name", conn.Sentinel.MasterName))
+	if err != nil {
+		return "", err
+	}
+	if len(master) != 2 {
+		return "", fmt.Errorf("unexpected sentinel reply %v", master)
+	}
+
+	return net.JoinHostPort(master[0], master[1]), nil
 }
 
-func (c *redisClient) Get(ctx context.Context, key string) (interface{}, bool) {
-	conn := c.pool.Get()
-	defer conn.Close()
+// Set fails with ErrValueTooLarge when the encoded value exceeds MaxValueSize
+func (c *redisClient) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	val, err := c.codec.encode(value)
+	if err != nil {
+		return err
+	}
+
+	_, err = redis.String(c.do(ctx, "SET", setArgs(key, val, ttl)...))
+
+	return redisErr(err)
+}
+
+// setArgs only adds the expiry when ttl is positive, SET rejects a PX that is not
+func setArgs(key string, val []byte, ttl time.Duration) redis.Args {
+	args := redis.Args{key, val}
+	if ttl > 0 {
+		args = args.Add("PX", ttl.Milliseconds())
+	}
+	return args
+}
 
-	exists, err := redis.Bool(conn.Do("EXISTS", key))
+// Get returns the value as encoded by the codec, use GetStruct to decode it
+func (c *redisClient) Get(ctx context.Context, key string) (interface{}, error) {
+	result, err := redis.Bytes(c.do(ctx, "GET", key))
+	if err != nil {
+		return nil, redisErr(err)
+	}
 
+	decompressed, err := c.codec.decompress(result)
 	if err != nil {
-		logger.E(ctx, err, "[RedisCache] failed while checking key in cache",
-			logger.Field("key", key),
-		)
+		return nil, err
 	}
+	return string(decompressed), nil
+}
 
-	if !exists {
-		return nil, false
+func (c *redisClient) GetStruct(ctx context.Context, key string, dest interface{}) error {
+	result, err := redis.Bytes(c.do(ctx, "GET", key))
+	if err != nil {
+		return redisErr(err)
 	}
 
-	result, err := redis.String(conn.Do("GET", key))
+	return c.codec.decode(result, dest)
+}
+
+func (c *redisClient) Exists(ctx context.Context, key string) (bool, error) {
+	exists, err := redis.Bool(c.do(ctx, "EXISTS", key))
 	if err != nil {
-		logger.E(ctx, err, "[RedisCache] error while getting value from cache",
-			logger.Field("key", key),
-		)
-		return nil, false
+		return false, redisErr(err)
 	}
-	return result, true
+
+	return exists, nil
 }
 
-func (c *redisClient) GetStruct(ctx context.Context, key string, dest interface{}) bool {
-	conn := c.pool.Get()
+// incrScript increments the counter and sets the expiry only when the counter has none yet
+var incrScript = redis.NewScript(1, `
+local value = redis.call('INCRBY', KEYS[1], ARGV[1])
+if tonumber(ARGV[2]) > 0 and redis.call('PTTL', KEYS[1]) == -1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return value
+`)
+
+const (
+	scanBatchSize = 500
+)
+
+func (c *redisClient) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	_, err := c.do(ctx, "DEL", redis.Args{}.AddFlat(keys)...)
+	return redisErr(err)
+}
+
+func (c *redisClient) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	defer datastoreSegment(ctx, "scan").End()
+
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return 0, redisErr(err)
+	}
 	defer conn.Close()
 
-	result, found := c.Get(ctx, key)
-	if !found {
-		return false
+	deleted := 0
+	cursor := 0
+	for {
+		values, err := redis.Values(redis.DoContext(conn, ctx, "SCAN", cursor, "MATCH", escapePattern(prefix)+"*", "COUNT", scanBatchSize))
+		if err != nil {
+			return deleted, redisErr(err)
+		}
+
+		cursor, _ = redis.Int(values[0], nil)
+		keys, _ := redis.Strings(values[1], nil)
+		if len(keys) > 0 {
+			count, err := redis.Int(redis.DoContext(conn, ctx, "UNLINK", redis.Args{}.AddFlat(keys)...))
+			if err != nil {
+				return deleted, redisErr(err)
+			}
+			deleted += count
+		}
+
+		if cursor == 0 {
+			return deleted, nil
+		}
+	}
+}
+
+// TTL returns zero for keys without expiry and ErrCacheMiss for missing keys
+func (c *redisClient) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := redis.Int64(c.do(ctx, "PTTL", key))
+	if err != nil {
+		return 0, redisErr(err)
 	}
 
-	if err := json.Unmarshal([]byte(result.(string)), dest); err != nil {
-		logger.E(ctx, err, "[RedisCache] error while unmarshaling value fetched from cache",
-			logger.Field("key", key),
-			logger.Field("value", result),
-		)
-		return false
+	switch ttl {
+	case -2:
+		return 0, ErrCacheMiss
+	case -1:
+		return 0, nil
+	default:
+		return time.Duration(ttl) * time.Millisecond, nil
 	}
-	return true
 }
 
-func (c *redisClient) Exists(ctx context.Context, key string) bool {
-	conn := c.pool.Get()
+// expireScript removes the expiry of the key when ttl is not positive, PEXPIRE would delete it
+var expireScript = redis.NewScript(1, `
+if tonumber(ARGV[1]) > 0 then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('PERSIST', KEYS[1])
+return 1
+`)
+
+// Expire keeps the key without expiry when ttl is not positive
+func (c *redisClient) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	updated, err := redis.Bool(c.eval(ctx, expireScript, key, ttl.Milliseconds()))
+	if err != nil {
+		return redisErr(err)
+	}
+	if !updated {
+		return ErrCacheMiss
+	}
+
+	return nil
+}
+
+func (c *redisClient) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	value, err := redis.Int64(c.eval(ctx, incrScript, key, delta, ttl.Milliseconds()))
+	if err != nil {
+		return 0, redisErr(err)
+	}
+
+	return value, nil
+}
+
+func (c *redisClient) Decr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	return c.Incr(ctx, key, -delta, ttl)
+}
+
+func (c *redisClient) SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error) {
+	val, err := c.codec.encode(value)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = redis.String(c.do(ctx, "SET", setArgs(key, val, ttl).Add("NX")...))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	if err != nil {
+		return false, redisErr(err)
+	}
+
+	return true, nil
+}
+
+// MGet returns the values of the keys found in the cache, missing keys are left out
+func (c *redisClient) MGet(ctx context.Context, keys ...string) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, len(keys))
+	if len(keys) == 0 {
+		return result, nil
+	}
+
+	values, err := redis.Values(c.do(ctx, "MGET", redis.Args{}.AddFlat(keys)...))
+	if err != nil {
+		return result, redisErr(err)
+	}
+
+	for i, value := range values {
+		if value == nil {
+			continue
+		}
+		raw, _ := redis.Bytes(value, nil)
+		decompressed, err := c.codec.decompress(raw)
+		if err != nil {
+			return result, err
+		}
+		result[keys[i]] = string(decompressed)
+	}
+
+	return result, nil
+}
+
+func (c *redisClient) MSet(ctx context.Context, values map[string]interface{}, ttl time.Duration) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	serialized := make(map[string][]byte, len(values))
+	for key, value := range values {
+		val, err := c.codec.encode(value)
+		if err != nil {
+			return err
+		}
+		serialized[key] = val
+	}
+
+	defer datastoreSegment(ctx, "mset").End()
+
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return redisErr(err)
+	}
 	defer conn.Close()
 
-	exists, err := redis.Bool(conn.Do("EXISTS", key))
+	// pipelining the writes so that all of them cost a single round trip
+	for key, val := range serialized {
+		if err := conn.Send("SET", setArgs(key, val, ttl)...); err != nil {
+			return redisErr(err)
+		}
+	}
+
+	if err := conn.Flush(); err != nil {
+		return redisErr(err)
+	}
+
+	var firstErr error
+	for range serialized {
+		if _, err := redis.ReceiveContext(conn, ctx); err != nil && firstErr == nil {
+			firstErr = redisErr(err)
+		}
+	}
+
+	return firstErr
+}
+
+// dial opens a connection outside of the pool, for commands such as SUBSCRIBE that hold it
+func (c *redisClient) dial(ctx context.Context) (redis.Conn, error) {
+	return c.pool.DialContext(ctx)
+}
+
+// SetWithTags keeps the value without expiry when ttl is not positive
+func (c *redisClient) SetWithTags(ctx context.Context, key string, value interface{}, ttl time.Duration, tags ...string) error {
+	val, err := c.codec.encode(value)
+	if err != nil {
+		return err
+	}
+
+	args := redis.Args{}.Add(len(tags)+1, key).AddFlat(tagArgs(tags)[1:]).Add(val, ttl.Milliseconds(), tagPruneSample)
+
+	_, err = c.eval(ctx, setWithTagsScript, args...)
+	return redisErr(err)
+}
+
+func (c *redisClient) InvalidateTag(ctx context.Context, tags ...string) (int, error) {
+	if len(tags) == 0 {
+		return 0, nil
+	}
+
+	deleted, err := redis.Int(c.eval(ctx, invalidateTagsScript, tagArgs(tags)...))
+	if err != nil {
+		return 0, redisErr(err)
+	}
+	return deleted, nil
+}
+
+// tagMembers returns the keys set with any of the tags
+func (c *redisClient) tagMembers(ctx context.Context, tags []string) ([]string, error) {
+	members, err := redis.Strings(c.do(ctx, "SUNION", tagArgs(tags)[1:]...))
+	if err != nil {
+		return nil, redisErr(err)
+	}
+	return members, nil
+}
+
+// tagArgs lists the tag sets preceded by their count, as expected by the variadic scripts
+func tagArgs(tags []string) redis.Args {
+	args := redis.Args{}.Add(len(tags))
+	for _, tag := range tags {
+		args = args.Add(tagKey(tag))
+	}
+	return args
+}
+
+// do runs a single command, waiting for a connection and the reply no longer than ctx allows
+func (c *redisClient) do(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	defer datastoreSegment(ctx, strings.ToLower(cmd)).End()
+
+	conn, err := c.pool.GetContext(ctx)
 	if err != nil {
-		logger.E(ctx, err, "[RedisCache] failed while checking key in cache",
-			logger.Field("key", key),
-		)
-		return false
+		return nil, err
 	}
+	defer conn.Close()
 
-	return exists
+	return redis.DoContext(conn, ctx, cmd, args...)
 }
 
-func CloseRedisCache() {
-	err := redisCacheClient.pool.Close()
+// eval runs the script, waiting for a connection and the reply no longer than ctx allows
+func (c *redisClient) eval(ctx context.Context, script *redis.Script, keysAndArgs ...interface{}) (interface{}, error) {
+	defer datastoreSegment(ctx, "evalsha").End()
+
+	conn, err := c.pool.GetContext(ctx)
 	if err != nil {
-		panic(fmt.Sprintf("failed to close redis pool %v", err.Error()))
+		return nil, err
+	}
+	defer conn.Close()
+
+	return script.DoContext(ctx, conn, keysAndArgs...)
+}
+
+// datastoreSegment traces the redis command in the New Relic transaction of the context, if any
+func datastoreSegment(ctx context.Context, op string) *newrelic.DatastoreSegment {
+	return &newrelic.DatastoreSegment{
+		StartTime: newrelic.FromContext(ctx).StartSegmentNow(),
+		Product:   newrelic.DatastoreRedis,
+		Operation: op,
+	}
+}
+
+// escapePattern escapes the glob characters of a SCAN MATCH pattern
+func escapePattern(pattern string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+	return replacer.Replace(pattern)
+}
+
+// RedisHealthCheck pings redis, meant for readiness endpoints
+func RedisHealthCheck(ctx context.Context) error {
+	if redisCacheClient == nil {
+		return ErrNotInitialised
+	}
+	return redisCacheClient.ping(ctx)
+}
+
+func RedisPoolStats() (PoolStats, error) {
+	if redisCacheClient == nil {
+		return PoolStats{}, ErrNotInitialised
+	}
+
+	stats := redisCacheClient.pool.Stats()
+	return PoolStats{
+		ActiveCount:  stats.ActiveCount,
+		IdleCount:    stats.IdleCount,
+		WaitCount:    stats.WaitCount,
+		WaitDuration: stats.WaitDuration,
+	}, nil
+}
+
+// CloseRedisCache closes the pool, the cache has to be initialised again to be used
+func CloseRedisCache() error {
+	if redisCacheClient == nil {
+		return ErrNotInitialised
+	}
+
+	pool := redisCacheClient.pool
+	redisCacheClient = nil
+	if err := pool.Close(); err != nil {
+		return fmt.Errorf("failed to close redis pool: %w", err)
+	}
+	return nil
+}
+
+func (c *redisClient) ping(ctx context.Context) error {
+	if _, err := redis.String(c.do(ctx, "PING")); err != nil {
+		return redisErr(err)
 	}
+	return nil
 }
@@ -3,74 +3,430 @@ package cache
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/dgraph-io/ristretto"
+	"github.com/dgraph-io/ristretto/z"
 )
 
 type ristrettoClient struct {
 	client *ristretto.Cache
+	codec  *CodecOpts
+	keys   *ristrettoKeys
+	tags   *ristrettoTags
+	// writeLock serialises read-modify-write operations such as Incr and SetNX
+	writeLock sync.Mutex
 }
 
-var ristrettoCacheClient *ristretto.Cache
+type RistrettoCacheOpts struct {
+	NumCounters int64
+	MaxCost     int64
+	// MaxValueSize makes writes of values bigger than this many bytes, once encoded with Codec,
+	// fail with ErrValueTooLarge. The encoded size is then also used as the cost of the entry.
+	MaxValueSize int
+	// Codec is only used to measure values when MaxValueSize is set, defaults to JSONCodec
+	Codec Codec
+	// KeyNamespace sets the prefix of the keys built by GetKey, see SetKeyNamespace
+	KeyNamespace *KeyNamespaceOpts
+	// Metrics turns on ristretto's internal counters, read with RistrettoMetrics. It has a small overhead.
+	Metrics bool
+}
 
-func NewRistrettoCache() Cache {
-	return &ristrettoClient{
-		client: ristrettoCacheClient,
-	}
+type RistrettoStats struct {
+	Hits         uint64  `json:"hits"`
+	Misses       uint64  `json:"misses"`
+	Ratio        float64 `json:"ratio"`
+	KeysAdded    uint64  `json:"keys_added"`
+	KeysUpdated  uint64  `json:"keys_updated"`
+	KeysEvicted  uint64  `json:"keys_evicted"`
+	CostAdded    uint64  `json:"cost_added"`
+	CostEvicted  uint64  `json:"cost_evicted"`
+	SetsDropped  uint64  `json:"sets_dropped"`
+	SetsRejected uint64  `json:"sets_rejected"`
+	GetsDropped  uint64  `json:"gets_dropped"`
+	GetsKept     uint64  `json:"gets_kept"`
+}
+
+// ristrettoKeys keeps the plain keys stored in ristretto, which only knows their hashes,
+// so that they can be looked up by prefix
+type ristrettoKeys struct {
+	mu   sync.RWMutex
+	keys map[uint64]string
+}
+
+var ristrettoCacheClient *ristrettoClient
+
+func NewRistrettoCache() ExtendedCache {
+	return newBoolCache(NewRistrettoCacheV2(), "RistrettoCache")
+}
+
+func NewRistrettoCacheV2() CacheV2 {
+	return instrument(ristrettoCacheClient, "ristretto")
 }
 
 func InitRistrettoCache(cost int64, counters int64) {
-	client, err := ristretto.NewCache(&ristretto.Config{
+	InitRistrettoCacheWithOpts(&RistrettoCacheOpts{
 		NumCounters: counters,
 		MaxCost:     cost,
-		BufferItems: 64,
 	})
+}
 
+func InitRistrettoCacheWithOpts(opts *RistrettoCacheOpts) {
+	client, err := newRistrettoClient(opts)
 	if err != nil {
 		panic(err)
 	}
 
 	ristrettoCacheClient = client
+	if opts.KeyNamespace != nil {
+		SetKeyNamespace(opts.KeyNamespace)
+	}
+}
+
+// newRistrettoClient creates a ristretto instance with its own key and tag indexes
+func newRistrettoClient(opts *RistrettoCacheOpts) (*ristrettoClient, error) {
+	c := &ristrettoClient{
+		codec: &CodecOpts{
+			Codec:        opts.Codec,
+			MaxValueSize: opts.MaxValueSize,
+		},
+		keys: &ristrettoKeys{keys: map[uint64]string{}},
+		tags: newRistrettoTags(),
+	}
+
+	client, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters: opts.NumCounters,
+		MaxCost:     opts.MaxCost,
+		BufferItems: 64,
+		Metrics:     opts.Metrics,
+		OnEvict:     c.forget,
+		OnReject:    c.forget,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.client = client
+	return c, nil
 }
 
-// NOTE: Please don't put huge json into the case
-// TODO: Add safe guard to checkout the value to be cached, if bigger than threshold should raise exception
-func (c *ristrettoClient) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) bool {
-	resp := c.client.SetWithTTL(key, value, 0, ttl)
+// Set stores the value as is, it fails with ErrValueTooLarge when MaxValueSize is configured
+// and the encoded value exceeds it
+func (c *ristrettoClient) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	cost, err := c.cost(value)
+	if err != nil {
+		return err
+	}
+
+	// indexed first, a write rejected by the admission policy is unindexed by OnReject while it is processed
+	added := c.keys.add(key)
+	resp := c.client.SetWithTTL(key, value, cost, ristrettoTTL(ttl))
 	c.client.Wait()
-	return resp
+	if !resp {
+		// a dropped write leaves the previous value, if any, in place
+		if added {
+			c.keys.delete(key)
+		}
+		return ErrNotStored
+	}
+	return nil
 }
 
-func (c *ristrettoClient) Get(ctx context.Context, key string) (interface{}, bool) {
-	return c.client.Get(key)
+func (c *ristrettoClient) Get(ctx context.Context, key string) (interface{}, error) {
+	value, found := c.client.Get(key)
+	if !found {
+		return nil, ErrCacheMiss
+	}
+	return value, nil
 }
 
-func (c *ristrettoClient) GetStruct(ctx context.Context, key string, dest interface{}) bool {
+// GetStruct assigns the stored value to dest when their types match, falling back to a JSON
+// round trip otherwise (e.g. when a map was stored and a struct is expected)
+func (c *ristrettoClient) GetStruct(ctx context.Context, key string, dest interface{}) error {
 	value, found := c.client.Get(key)
 
 	if !found {
-		return false
+		return ErrCacheMiss
+	}
+
+	if assignValue(value, dest) {
+		return nil
 	}
 
 	bytes, err := json.Marshal(value)
 
 	if err != nil {
-		return false
+		return wrapErr(ErrSerialization, err)
 	}
 
 	if err := json.Unmarshal(bytes, dest); err != nil {
-		return false
+		return wrapErr(ErrSerialization, err)
 	}
 
-	return true
+	return nil
 }
 
-func (c *ristrettoClient) Exists(ctx context.Context, key string) bool {
+func (c *ristrettoClient) Exists(ctx context.Context, key string) (bool, error) {
 	_, b := c.client.Get(key)
-	return b
+	return b, nil
+}
+
+func (c *ristrettoClient) Delete(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		c.del(key)
+	}
+	return nil
+}
+
+func (c *ristrettoClient) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	keys := c.keys.withPrefix(prefix)
+	deleted := 0
+	for _, key := range keys {
+		if _, found := c.client.Get(key); found {
+			deleted++
+		}
+		c.del(key)
+	}
+	return deleted, nil
+}
+
+func (c *ristrettoClient) SetWithTags(ctx context.Context, key string, value interface{}, ttl time.Duration, tags ...string) error {
+	if err := c.Set(ctx, key, value, ttl); err != nil {
+		return err
+	}
+	c.tags.tag(key, tags)
+	return nil
+}
+
+func (c *ristrettoClient) InvalidateTag(ctx context.Context, tags ...string) (int, error) {
+	deleted := 0
+	for _, key := range c.tags.keysOf(tags) {
+		if _, found := c.client.Get(key); found {
+			deleted++
+		}
+		c.del(key)
+	}
+	return deleted, nil
+}
+
+func (c *ristrettoClient) del(key string) {
+	c.client.Del(key)
+	c.keys.delete(key)
+	c.tags.untag(key)
+}
+
+// forget drops an evicted or rejected key from the indexes
+func (c *ristrettoClient) forget(item *ristretto.Item) {
+	if key, found := c.keys.remove(item.Key); found {
+		c.tags.untag(key)
+	}
+}
+
+func (c *ristrettoClient) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, found := c.client.GetTTL(key)
+	if !found {
+		return 0, ErrCacheMiss
+	}
+	return ttl, nil
+}
+
+func (c *ristrettoClient) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	value, found := c.client.Get(key)
+	if !found {
+		return ErrCacheMiss
+	}
+	return c.Set(ctx, key, value, ttl)
+}
+
+func (c *ristrettoClient) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	var current int64
+	if value, found := c.client.Get(key); found {
+		counter, ok := value.(int64)
+		if !ok {
+			return 0, wrapErr(ErrSerialization, fmt.Errorf("value of %s is not a counter", key))
+		}
+		current = counter
+		// keeping the expiry of the existing counter
+		ttl, _ = c.client.GetTTL(key)
+	}
+
+	current += delta
+	if err := c.Set(ctx, key, current, ttl); err != nil {
+		return 0, err
+	}
+	return current, nil
+}
+
+func (c *ristrettoClient) Decr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	return c.Incr(ctx, key, -delta, ttl)
+}
+
+func (c *ristrettoClient) SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	if _, found := c.client.Get(key); found {
+		return false, nil
+	}
+	if err := c.Set(ctx, key, value, ttl); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (c *ristrettoClient) MGet(ctx context.Context, keys ...string) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		if value, found := c.client.Get(key); found {
+			result[key] = value
+		}
+	}
+	return result, nil
+}
+
+func (c *ristrettoClient) MSet(ctx context.Context, values map[string]interface{}, ttl time.Duration) error {
+	costs := make(map[string]int64, len(values))
+	for key, value := range values {
+		cost, err := c.cost(value)
+		if err != nil {
+			return err
+		}
+		costs[key] = cost
+	}
+
+	stored := true
+	for key, value := range values {
+		added := c.keys.add(key)
+		if !c.client.SetWithTTL(key, value, costs[key], ristrettoTTL(ttl)) {
+			stored = false
+			if added {
+				c.keys.delete(key)
+			}
+		}
+	}
+	c.client.Wait()
+	if !stored {
+		return ErrNotStored
+	}
+	return nil
+}
+
+// cost measures the encoded value when a size limit is configured, ristretto's default cost is used otherwise
+func (c *ristrettoClient) cost(value interface{}) (int64, error) {
+	if c.codec == nil || c.codec.MaxValueSize <= 0 {
+		return 0, nil
+	}
+
+	data, err := c.codec.encode(value)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(data)), nil
+}
+
+// assignValue sets dest to value without serialization when value, or what it points to, fits in dest
+func assignValue(value interface{}, dest interface{}) bool {
+	destValue := reflect.ValueOf(dest)
+	if destValue.Kind() != reflect.Pointer || destValue.IsNil() {
+		return false
+	}
+
+	target := destValue.Elem()
+	source := reflect.ValueOf(value)
+	if !source.IsValid() {
+		return false
+	}
+
+	if source.Type().AssignableTo(target.Type()) {
+		target.Set(source)
+		return true
+	}
+	if source.Kind() == reflect.Pointer && !source.IsNil() && source.Elem().Type().AssignableTo(target.Type()) {
+		target.Set(source.Elem())
+		return true
+	}
+
+	return false
+}
+
+// add indexes the key, returning false when it already was
+func (k *ristrettoKeys) add(key string) bool {
+	hash, _ := z.KeyToHash(key)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	_, found := k.keys[hash]
+	k.keys[hash] = key
+	return !found
+}
+
+func (k *ristrettoKeys) delete(key string) {
+	hash, _ := z.KeyToHash(key)
+	k.mu.Lock()
+	delete(k.keys, hash)
+	k.mu.Unlock()
+}
+
+// remove forgets the key of a hash, returning it when it was known
+func (k *ristrettoKeys) remove(hash uint64) (string, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key, found := k.keys[hash]
+	delete(k.keys, hash)
+	return key, found
+}
+
+func (k *ristrettoKeys) withPrefix(prefix string) []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	var keys []string
+	for _, key := range k.keys {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// RistrettoMetrics returns ristretto's internal counters, all zero unless Metrics was enabled
+func RistrettoMetrics() RistrettoStats {
+	metrics := ristrettoCacheClient.client.Metrics
+	return RistrettoStats{
+		Hits:         metrics.Hits(),
+		Misses:       metrics.Misses(),
+		Ratio:        metrics.Ratio(),
+		KeysAdded:    metrics.KeysAdded(),
+		KeysUpdated:  metrics.KeysUpdated(),
+		KeysEvicted:  metrics.KeysEvicted(),
+		CostAdded:    metrics.CostAdded(),
+		CostEvicted:  metrics.CostEvicted(),
+		SetsDropped:  metrics.SetsDropped(),
+		SetsRejected: metrics.SetsRejected(),
+		GetsDropped:  metrics.GetsDropped(),
+		GetsKept:     metrics.GetsKept(),
+	}
 }
 
 func CloseRistrettoCache() {
-	ristrettoCacheClient.Close()
+	ristrettoCacheClient.client.Close()
+}
+
+// ristrettoTTL maps a negative ttl to no expiry, ristretto rejects the value otherwise
+func ristrettoTTL(ttl time.Duration) time.Duration {
+	if ttl < 0 {
+		return 0
+	}
+	return ttl
 }
@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"sync"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// setWithTagsScript sets the value and adds the key to the tag sets, which are kept as long as
+// their longest lived key. A few random members of each set are checked on every call and the
+// expired or deleted ones removed, the sets would otherwise only shrink when invalidated.
+var setWithTagsScript = redis.NewScript(-1, `
+local ttl = tonumber(ARGV[2])
+local pruneSample = tonumber(ARGV[3])
+if ttl > 0 then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ttl)
+else
+	redis.call('SET', KEYS[1], ARGV[1])
+end
+
+for i = 2, #KEYS do
+	for _, member in ipairs(redis.call('SRANDMEMBER', KEYS[i], pruneSample)) do
+		if redis.call('EXISTS', member) == 0 then
+			redis.call('SREM', KEYS[i], member)
+		end
+	end
+	redis.call('SADD', KEYS[i], KEYS[1])
+	if ttl <= 0 then
+		redis.call('PERSIST', KEYS[i])
+	else
+		local tagTTL = redis.call('PTTL', KEYS[i])
+		if (tagTTL == -1 and redis.call('SCARD', KEYS[i]) == 1) or (tagTTL >= 0 and tagTTL < ttl) then
+			redis.call('PEXPIRE', KEYS[i], ttl)
+		end
+	end
+end
+return 1
+`)
+
+// invalidateTagsScript deletes the keys of the tag sets and the sets themselves
+var invalidateTagsScript = redis.NewScript(-1, `
+local deleted = 0
+for i = 1, #KEYS do
+	for _, key in ipairs(redis.call('SMEMBERS', KEYS[i])) do
+		deleted = deleted + redis.call('DEL', key)
+	end
+	redis.call('DEL', KEYS[i])
+end
+return deleted
+`)
+
+// tagPruneSample is how many members of each tag set setWithTagsScript checks
+const tagPruneSample = 10
+
+// ristrettoTags maps the tags to the ristretto keys set with them and back
+type ristrettoTags struct {
+	mu      sync.Mutex
+	keys    map[string]map[string]struct{}
+	keyTags map[string][]string
+}
+
+func newRistrettoTags() *ristrettoTags {
+	return &ristrettoTags{
+		keys:    map[string]map[string]struct{}{},
+		keyTags: map[string][]string{},
+	}
+}
+
+// tagKey is the redis set holding the keys set with the tag
+func tagKey(tag string) string {
+	return GetKey("tags", tag)
+}
+
+// tag replaces the tags of the key, a key set again with other tags leaves its previous ones
+func (t *ristrettoTags) tag(key string, tags []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.removeKey(key)
+	for _, tag := range tags {
+		if t.keys[tag] == nil {
+			t.keys[tag] = map[string]struct{}{}
+		}
+		if _, tagged := t.keys[tag][key]; !tagged {
+			t.keys[tag][key] = struct{}{}
+			t.keyTags[key] = append(t.keyTags[key], tag)
+		}
+	}
+}
+
+// untag forgets the key once it is deleted or evicted
+func (t *ristrettoTags) untag(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.removeKey(key)
+}
+
+func (t *ristrettoTags) removeKey(key string) {
+	for _, tag := range t.keyTags[key] {
+		delete(t.keys[tag], key)
+		if len(t.keys[tag]) == 0 {
+			delete(t.keys, tag)
+		}
+	}
+	delete(t.keyTags, key)
+}
+
+// keysOf returns the keys set with any of the tags
+func (t *ristrettoTags) keysOf(tags []string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	unique := map[string]struct{}{}
+	for _, tag := range tags {
+		for key := range t.keys[tag] {
+			unique[key] = struct{}{}
+		}
+	}
+
+	keys := make([]string, 0, len(unique))
+	for key := range unique {
+		keys = append(keys, key)
+	}
+	return keys
+}
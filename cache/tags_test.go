@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRistrettoSetWithTagsReplacesTags(t *testing.T) {
+	client, err := newRistrettoClient(&RistrettoCacheOpts{NumCounters: 1e3, MaxCost: 1 << 20})
+	if err != nil {
+		t.Fatalf("newRistrettoClient: %v", err)
+	}
+	defer client.client.Close()
+	ctx := context.Background()
+
+	if err := client.SetWithTags(ctx, "user:1", "a", time.Minute, "old"); err != nil {
+		t.Fatalf("SetWithTags: %v", err)
+	}
+	if err := client.SetWithTags(ctx, "user:1", "b", time.Minute, "new"); err != nil {
+		t.Fatalf("SetWithTags: %v", err)
+	}
+
+	if deleted, _ := client.InvalidateTag(ctx, "old"); deleted != 0 {
+		t.Errorf("InvalidateTag(old) deleted %d keys, want 0", deleted)
+	}
+	if deleted, _ := client.InvalidateTag(ctx, "new"); deleted != 1 {
+		t.Errorf("InvalidateTag(new) deleted %d keys, want 1", deleted)
+	}
+}
+
+func TestRedisSetWithTagsPrunesDeletedKeys(t *testing.T) {
+	server := testRedis(t)
+	store := NewRedisCacheV2()
+	ctx := context.Background()
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := store.SetWithTags(ctx, key, key, 0, "letters"); err != nil {
+			t.Fatalf("SetWithTags: %v", err)
+		}
+	}
+	if err := store.Delete(ctx, "a", "b"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := store.SetWithTags(ctx, "d", "d", 0, "letters"); err != nil {
+		t.Fatalf("SetWithTags: %v", err)
+	}
+
+	members, err := server.Members(tagKey("letters"))
+	if err != nil {
+		t.Fatalf("Members: %v", err)
+	}
+	if len(members) != 2 {
+		t.Errorf("tag set members = %v, want the live keys c and d", members)
+	}
+}
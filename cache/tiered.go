@@ -0,0 +1,432 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/google/uuid"
+
+	"github.com/owlify/sparrow/logger"
+)
+
+const (
+	defaultInvalidationChannel = "sparrow:cache:invalidate"
+	defaultL1TTL               = time.Minute
+	defaultL1NumCounters       = 1e5
+	defaultL1MaxCost           = 1 << 26
+	resubscribeBackoff         = time.Second
+	// subscriptionPingInterval keeps the idle subscription alive, it is considered dead when nothing
+	// is received for twice the interval
+	subscriptionPingInterval = 30 * time.Second
+)
+
+type TieredCacheOpts struct {
+	// L1 sizes the ristretto instance of the tiered cache, which is separate from the one of
+	// InitRistrettoCache. Defaults to 1e5 counters and a max cost of 64MiB, KeyNamespace is ignored.
+	L1 *RistrettoCacheOpts
+	// L1TTL caps how long a value stays in ristretto, defaults to a minute
+	L1TTL time.Duration
+	// InvalidationChannel is the redis pub/sub channel used to evict L1 entries on every pod
+	InvalidationChannel string
+}
+
+// tieredClient reads ristretto first and falls back to redis. Values are kept in ristretto
+// in their serialized form, exactly as they are stored in redis.
+type tieredClient struct {
+	l1      *ristrettoClient
+	l2      *redisClient
+	opts    *TieredCacheOpts
+	origin  string
+	stopped chan struct{}
+
+	mu     sync.Mutex
+	conn   redis.Conn
+	closed bool
+}
+
+type invalidationMessage struct {
+	Origin string   `json:"origin"`
+	Keys   []string `json:"keys,omitempty"`
+	Prefix string   `json:"prefix,omitempty"`
+}
+
+var tieredCacheClient *tieredClient
+
+func NewTieredCache() ExtendedCache {
+	return newBoolCache(NewTieredCacheV2(), "TieredCache")
+}
+
+func NewTieredCacheV2() CacheV2 {
+	return instrument(tieredCacheClient, "tiered")
+}
+
+// InitTieredCache puts a dedicated ristretto instance in front of the redis cache, which must be
+// initialised first
+func InitTieredCache(opts *TieredCacheOpts) error {
+	tieredOpts := &TieredCacheOpts{}
+	if opts != nil {
+		*tieredOpts = *opts
+	}
+	if tieredOpts.L1TTL <= 0 {
+		tieredOpts.L1TTL = defaultL1TTL
+	}
+	if tieredOpts.InvalidationChannel == "" {
+		tieredOpts.InvalidationChannel = defaultInvalidationChannel
+	}
+
+	l1Opts := &RistrettoCacheOpts{}
+	if tieredOpts.L1 != nil {
+		*l1Opts = *tieredOpts.L1
+	}
+	if l1Opts.NumCounters <= 0 {
+		l1Opts.NumCounters = defaultL1NumCounters
+	}
+	if l1Opts.MaxCost <= 0 {
+		l1Opts.MaxCost = defaultL1MaxCost
+	}
+	tieredOpts.L1 = l1Opts
+
+	l1, err := newRistrettoClient(l1Opts)
+	if err != nil {
+		return fmt.Errorf("failed to create the tiered cache L1: %w", err)
+	}
+
+	tieredCacheClient = &tieredClient{
+		l1:      l1,
+		l2:      redisCacheClient,
+		opts:    tieredOpts,
+		origin:  uuid.NewString(),
+		stopped: make(chan struct{}),
+	}
+
+	go tieredCacheClient.subscribe()
+	return nil
+}
+
+func (c *tieredClient) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	if err := c.l2.Set(ctx, key, value, ttl); err != nil {
+		return err
+	}
+
+	c.publish(ctx, &invalidationMessage{Keys: []string{key}})
+	if val, err := c.l2.codec.codec().Marshal(value); err == nil {
+		c.setL1(ctx, key, string(val), ttl)
+	}
+	return nil
+}
+
+func (c *tieredClient) Get(ctx context.Context, key string) (interface{}, error) {
+	if value, err := c.l1.Get(ctx, key); err == nil {
+		return value, nil
+	}
+
+	value, err := c.l2.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.setL1(ctx, key, value, c.opts.L1TTL)
+	return value, nil
+}
+
+func (c *tieredClient) GetStruct(ctx context.Context, key string, dest interface{}) error {
+	value, err := c.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	return c.l2.codec.decode([]byte(value.(string)), dest)
+}
+
+func (c *tieredClient) Exists(ctx context.Context, key string) (bool, error) {
+	if exists, _ := c.l1.Exists(ctx, key); exists {
+		return true, nil
+	}
+	return c.l2.Exists(ctx, key)
+}
+
+func (c *tieredClient) Delete(ctx context.Context, keys ...string) error {
+	c.l1.Delete(ctx, keys...)
+	if err := c.l2.Delete(ctx, keys...); err != nil {
+		return err
+	}
+
+	c.publish(ctx, &invalidationMessage{Keys: keys})
+	return nil
+}
+
+func (c *tieredClient) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	c.l1.DeleteByPrefix(ctx, prefix)
+	deleted, err := c.l2.DeleteByPrefix(ctx, prefix)
+	if err != nil {
+		return deleted, err
+	}
+
+	c.publish(ctx, &invalidationMessage{Prefix: prefix})
+	return deleted, nil
+}
+
+func (c *tieredClient) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return c.l2.TTL(ctx, key)
+}
+
+func (c *tieredClient) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	if err := c.l2.Expire(ctx, key, ttl); err != nil {
+		return err
+	}
+
+	c.l1.Delete(ctx, key)
+	c.publish(ctx, &invalidationMessage{Keys: []string{key}})
+	return nil
+}
+
+func (c *tieredClient) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	value, err := c.l2.Incr(ctx, key, delta, ttl)
+	if err != nil {
+		return 0, err
+	}
+
+	c.l1.Delete(ctx, key)
+	c.publish(ctx, &invalidationMessage{Keys: []string{key}})
+	return value, nil
+}
+
+func (c *tieredClient) Decr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	return c.Incr(ctx, key, -delta, ttl)
+}
+
+func (c *tieredClient) SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error) {
+	set, err := c.l2.SetNX(ctx, key, value, ttl)
+	if err != nil || !set {
+		return set, err
+	}
+
+	// L1 may still hold a value of the key that expired or was deleted in redis
+	c.l1.Delete(ctx, key)
+	c.publish(ctx, &invalidationMessage{Keys: []string{key}})
+	return true, nil
+}
+
+func (c *tieredClient) MGet(ctx context.Context, keys ...string) (map[string]interface{}, error) {
+	result, _ := c.l1.MGet(ctx, keys...)
+
+	var missing []string
+	for _, key := range keys {
+		if _, found := result[key]; !found {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) == 0 {
+		return result, nil
+	}
+
+	values, err := c.l2.MGet(ctx, missing...)
+	if err != nil {
+		return result, err
+	}
+	for key, value := range values {
+		result[key] = value
+		c.setL1(ctx, key, value, c.opts.L1TTL)
+	}
+
+	return result, nil
+}
+
+func (c *tieredClient) MSet(ctx context.Context, values map[string]interface{}, ttl time.Duration) error {
+	if err := c.l2.MSet(ctx, values, ttl); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	c.l1.Delete(ctx, keys...)
+	c.publish(ctx, &invalidationMessage{Keys: keys})
+	return nil
+}
+
+func (c *tieredClient) SetWithTags(ctx context.Context, key string, value interface{}, ttl time.Duration, tags ...string) error {
+	if err := c.l2.SetWithTags(ctx, key, value, ttl, tags...); err != nil {
+		return err
+	}
+
+	c.publish(ctx, &invalidationMessage{Keys: []string{key}})
+	if val, err := c.l2.codec.codec().Marshal(value); err == nil {
+		c.setL1(ctx, key, string(val), ttl)
+	}
+	return nil
+}
+
+// InvalidateTag looks the tagged keys up in redis first so that every pod can drop them from L1
+func (c *tieredClient) InvalidateTag(ctx context.Context, tags ...string) (int, error) {
+	if len(tags) == 0 {
+		return 0, nil
+	}
+
+	keys, err := c.l2.tagMembers(ctx, tags)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted, err := c.l2.InvalidateTag(ctx, tags...)
+	if err != nil {
+		return deleted, err
+	}
+
+	if len(keys) > 0 {
+		c.l1.Delete(ctx, keys...)
+		c.publish(ctx, &invalidationMessage{Keys: keys})
+	}
+	return deleted, nil
+}
+
+func (c *tieredClient) setL1(ctx context.Context, key string, value interface{}, ttl time.Duration) {
+	if ttl <= 0 || ttl > c.opts.L1TTL {
+		ttl = c.opts.L1TTL
+	}
+	c.l1.Set(ctx, key, value, ttl)
+}
+
+// publish tells the other pods to drop the keys from their L1
+func (c *tieredClient) publish(ctx context.Context, msg *invalidationMessage) {
+	msg.Origin = c.origin
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	conn, err := c.l2.pool.GetContext(ctx)
+	if err == nil {
+		defer conn.Close()
+		_, err = redis.DoContext(conn, ctx, "PUBLISH", c.opts.InvalidationChannel, payload)
+	}
+	if err != nil {
+		logger.E(ctx, err, "[TieredCache] failed to publish invalidation",
+			logger.Field("keys", msg.Keys),
+			logger.Field("prefix", msg.Prefix),
+		)
+	}
+}
+
+// subscribe listens for invalidations until the cache is closed, resubscribing on errors.
+// Invalidations missed while disconnected are bounded by L1TTL.
+func (c *tieredClient) subscribe() {
+	ctx := context.Background()
+	for {
+		// a dedicated connection, the subscription would otherwise hold one of the pool forever
+		conn, err := c.l2.dial(ctx)
+		if err != nil {
+			logger.E(ctx, err, "[TieredCache] failed to connect for invalidations")
+		} else if c.listen(ctx, conn) {
+			return
+		}
+
+		select {
+		case <-c.stopped:
+			return
+		case <-time.After(resubscribeBackoff):
+		}
+	}
+}
+
+// listen receives the invalidations until the connection fails, it returns true once the cache is closed
+func (c *tieredClient) listen(ctx context.Context, conn redis.Conn) bool {
+	c.mu.Lock()
+	select {
+	case <-c.stopped:
+		c.mu.Unlock()
+		conn.Close()
+		return true
+	default:
+	}
+	c.conn = conn
+	psc := redis.PubSubConn{Conn: conn}
+	c.mu.Unlock()
+
+	if err := psc.Subscribe(c.opts.InvalidationChannel); err != nil {
+		logger.E(ctx, err, "[TieredCache] failed to subscribe to invalidations")
+	} else {
+		c.receive(ctx, psc)
+	}
+	psc.Close()
+
+	select {
+	case <-c.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+// receive waits on the subscription without the read timeout of the pool connections, pinging it so
+// that a dead connection is still noticed
+func (c *tieredClient) receive(ctx context.Context, psc redis.PubSubConn) {
+	done := make(chan struct{})
+	defer close(done)
+	go ping(psc, done)
+
+	for {
+		switch v := psc.ReceiveWithTimeout(2 * subscriptionPingInterval).(type) {
+		case redis.Message:
+			msg := &invalidationMessage{}
+			if err := json.Unmarshal(v.Data, msg); err != nil || msg.Origin == c.origin {
+				continue
+			}
+			if len(msg.Keys) > 0 {
+				c.l1.Delete(ctx, msg.Keys...)
+			}
+			if msg.Prefix != "" {
+				c.l1.DeleteByPrefix(ctx, msg.Prefix)
+			}
+		case error:
+			select {
+			case <-c.stopped:
+			default:
+				logger.E(ctx, v, "[TieredCache] invalidation subscription failed")
+			}
+			return
+		}
+	}
+}
+
+func ping(psc redis.PubSubConn, done <-chan struct{}) {
+	ticker := time.NewTicker(subscriptionPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			// a failed ping surfaces as a receive error once the read deadline passes
+			_ = psc.Ping("")
+		}
+	}
+}
+
+// CloseTieredCache stops the invalidation subscriber and releases L1, it does nothing when the
+// cache is not initialised or already closed
+func CloseTieredCache() {
+	if tieredCacheClient == nil {
+		return
+	}
+
+	tieredCacheClient.mu.Lock()
+	defer tieredCacheClient.mu.Unlock()
+	if tieredCacheClient.closed {
+		return
+	}
+	tieredCacheClient.closed = true
+
+	close(tieredCacheClient.stopped)
+	// closing the connection unblocks the subscriber
+	if tieredCacheClient.conn != nil {
+		tieredCacheClient.conn.Close()
+	}
+	tieredCacheClient.l1.client.Close()
+}
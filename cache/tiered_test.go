@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type cachedUser struct {
+	Name string `json:"name"`
+}
+
+func TestTieredCacheHasItsOwnL1(t *testing.T) {
+	testRedis(t)
+	InitRistrettoCache(1<<20, 1e4)
+	defer CloseRistrettoCache()
+	if err := InitTieredCache(nil); err != nil {
+		t.Fatalf("InitTieredCache: %v", err)
+	}
+	defer CloseTieredCache()
+
+	ctx := context.Background()
+	standalone, tiered := NewRistrettoCacheV2(), NewTieredCacheV2()
+	if err := standalone.Set(ctx, "user:1", cachedUser{Name: "standalone"}, 0); err != nil {
+		t.Fatalf("ristretto Set: %v", err)
+	}
+	if err := tiered.Set(ctx, "user:1", cachedUser{Name: "tiered"}, time.Minute); err != nil {
+		t.Fatalf("tiered Set: %v", err)
+	}
+
+	var user cachedUser
+	if err := standalone.GetStruct(ctx, "user:1", &user); err != nil || user.Name != "standalone" {
+		t.Errorf("ristretto GetStruct = %+v, %v, want the standalone value", user, err)
+	}
+	if err := tiered.GetStruct(ctx, "user:1", &user); err != nil || user.Name != "tiered" {
+		t.Errorf("tiered GetStruct = %+v, %v, want the tiered value", user, err)
+	}
+
+	if _, err := standalone.DeleteByPrefix(ctx, "user:"); err != nil {
+		t.Fatalf("ristretto DeleteByPrefix: %v", err)
+	}
+	if _, err := tieredCacheClient.l1.Get(ctx, "user:1"); err != nil {
+		t.Errorf("tiered L1 lost the key deleted from the standalone ristretto: %v", err)
+	}
+}
+
+func TestInitTieredCacheDoesNotMutateOpts(t *testing.T) {
+	testRedis(t)
+	opts := &TieredCacheOpts{}
+	if err := InitTieredCache(opts); err != nil {
+		t.Fatalf("InitTieredCache: %v", err)
+	}
+	defer CloseTieredCache()
+
+	if opts.L1 != nil || opts.L1TTL != 0 || opts.InvalidationChannel != "" {
+		t.Errorf("opts were given defaults: %+v", opts)
+	}
+}
+
+func TestCloseTieredCache(t *testing.T) {
+	tieredCacheClient = nil
+	// closing a cache that was never initialised does nothing
+	CloseTieredCache()
+
+	testRedis(t)
+	if err := InitTieredCache(nil); err != nil {
+		t.Fatalf("InitTieredCache: %v", err)
+	}
+	CloseTieredCache()
+	CloseTieredCache()
+}
@@ -14,6 +14,7 @@ var (
 type baseError struct {
 	code string
 	msg  string
+	err  error
 }
 
 func (f *baseError) Error() string {
@@ -24,6 +25,10 @@ func (f *baseError) Code() string {
 	return f.code
 }
 
+func (f *baseError) Unwrap() error {
+	return f.err
+}
+
 func New(message string) error {
 	return &baseError{
 		msg: message,
@@ -38,6 +43,7 @@ func NewWithErr(code string, err error) error {
 	return &baseError{
 		code: code,
 		msg:  err.Error(),
+		err:  err,
 	}
 }
 
@@ -5,28 +5,41 @@ import (
 	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
+	"time"
 
 	"github.com/tuvistavie/securerandom"
 )
 
+const (
+	ServiceIDHeader        = "SIMPL-SERVICE-ID"
+	ServiceNonceHeader     = "SIMPL-SERVICE-NONCE"
+	ServiceSignatureHeader = "SIMPL-SERVICE-SIGNATURE"
+)
+
 /*
 Utility methods for http client calls
 */
 func generateHmacSignature(serviceID, serviceKey string) (string, string) {
-	nonce, _ := securerandom.Hex(16)
+	random, _ := securerandom.Hex(16)
+	// the signing time prefixes the nonce so that receivers can reject stale signatures
+	nonce := fmt.Sprintf("%d.%s", time.Now().Unix(), random)
+	return nonce, ServiceSignature(serviceID, serviceKey, nonce)
+}
+
+// ServiceSignature is the hex HMAC-SHA1 of "nonce-serviceID" with the service key
+func ServiceSignature(serviceID, serviceKey, nonce string) string {
 	key := fmt.Sprintf("%s-%s", nonce, serviceID)
 	signature := hmac.New(sha1.New, []byte(serviceKey))
 	signature.Write([]byte(key))
-	serviceSignature := hex.EncodeToString(signature.Sum(nil))
-	return nonce, serviceSignature
+	return hex.EncodeToString(signature.Sum(nil))
 }
 
 func headersForInternalRequest(serviceID, serviceKey string) Headers {
 	nonce, serviceSignature := generateHmacSignature(serviceID, serviceKey)
 	headers := Headers{
-		"SIMPL-SERVICE-ID":        serviceID,
-		"SIMPL-SERVICE-NONCE":     nonce,
-		"SIMPL-SERVICE-SIGNATURE": serviceSignature,
+		ServiceIDHeader:        serviceID,
+		ServiceNonceHeader:     nonce,
+		ServiceSignatureHeader: serviceSignature,
 	}
 	return headers
 }
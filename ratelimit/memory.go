@@ -0,0 +1,121 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the keys idle long enough to be at their full limit are evicted
+const sweepInterval = time.Minute
+
+type memoryLimiter struct {
+	mu      sync.Mutex
+	windows map[string][]time.Time
+	buckets map[string]*bucket
+	// idleAt is when each key is back to its full limit and can be forgotten
+	idleAt    map[string]time.Time
+	nextSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	ts     time.Time
+}
+
+// NewMemoryLimiter keeps limits in process, meant for tests and single instance services
+func NewMemoryLimiter() Limiter {
+	return &memoryLimiter{
+		windows:   map[string][]time.Time{},
+		buckets:   map[string]*bucket{},
+		idleAt:    map[string]time.Time{},
+		nextSweep: time.Now().Add(sweepInterval),
+	}
+}
+
+func (l *memoryLimiter) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	limit, err := limit.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.After(l.nextSweep) {
+		l.sweep(now)
+	}
+
+	var result *Result
+	if limit.Algorithm == TokenBucket {
+		result = l.allowTokenBucket(key, limit, now)
+	} else {
+		result = l.allowSlidingWindow(key, limit, now)
+	}
+	l.idleAt[key] = now.Add(result.ResetAfter)
+	return result, nil
+}
+
+// sweep evicts the keys back to their full limit, they would otherwise be kept for every client ever seen
+func (l *memoryLimiter) sweep(now time.Time) {
+	for key, idleAt := range l.idleAt {
+		if now.After(idleAt) {
+			delete(l.windows, key)
+			delete(l.buckets, key)
+			delete(l.idleAt, key)
+		}
+	}
+	l.nextSweep = now.Add(sweepInterval)
+}
+
+func (l *memoryLimiter) allowSlidingWindow(key string, limit Limit, now time.Time) *Result {
+	windowStart := now.Add(-limit.Period)
+	requests := l.windows[key]
+	for len(requests) > 0 && !requests[0].After(windowStart) {
+		requests = requests[1:]
+	}
+
+	result := &Result{Limit: limit.Rate}
+	if len(requests) < limit.Rate {
+		requests = append(requests, now)
+		result.Allowed = true
+		result.Remaining = limit.Rate - len(requests)
+		result.ResetAfter = limit.Period
+	} else {
+		result.RetryAfter = requests[0].Add(limit.Period).Sub(now)
+		result.ResetAfter = requests[len(requests)-1].Add(limit.Period).Sub(now)
+	}
+
+	l.windows[key] = requests
+	return result
+}
+
+func (l *memoryLimiter) allowTokenBucket(key string, limit Limit, now time.Time) *Result {
+	rate := limit.tokensPerMs()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), ts: now}
+		l.buckets[key] = b
+	}
+
+	elapsed := float64(now.Sub(b.ts)) / float64(time.Millisecond)
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+math.Max(0, elapsed)*rate)
+	b.ts = now
+
+	result := &Result{Limit: limit.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = msDuration((1 - b.tokens) / rate)
+	}
+	result.Remaining = int(b.tokens)
+	result.ResetAfter = msDuration((float64(limit.Burst) - b.tokens) / rate)
+	return result
+}
+
+func msDuration(ms float64) time.Duration {
+	return time.Duration(math.Ceil(ms)) * time.Millisecond
+}
@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/owlify/sparrow/errors"
+)
+
+type Algorithm string
+
+const (
+	// SlidingWindow allows Rate requests in any Period long window
+	SlidingWindow Algorithm = "sliding_window"
+	// TokenBucket refills Rate tokens every Period and allows bursts up to Burst
+	TokenBucket Algorithm = "token_bucket"
+)
+
+var (
+	ErrInvalidLimit = errors.NewWithCodef("rate_limit_invalid", "rate limit must have a positive rate and a period of at least a millisecond")
+)
+
+type Limit struct {
+	// Algorithm defaults to SlidingWindow
+	Algorithm Algorithm
+	Rate      int
+	Period    time.Duration
+	// Burst is the token bucket capacity, defaults to Rate
+	Burst int
+}
+
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long to wait before the next request can be allowed, zero when allowed
+	RetryAfter time.Duration
+	// ResetAfter is how long until the limit is fully replenished
+	ResetAfter time.Duration
+}
+
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (*Result, error)
+}
+
+func PerSecond(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Second}
+}
+
+func PerMinute(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Minute}
+}
+
+func PerHour(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Hour}
+}
+
+// Wait blocks until the limiter allows the key or the context is done, meant for throttling
+// outbound calls
+func Wait(ctx context.Context, limiter Limiter, key string, limit Limit) error {
+	for {
+		result, err := limiter.Allow(ctx, key, limit)
+		if err != nil {
+			return err
+		}
+		if result.Allowed {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(result.RetryAfter):
+		}
+	}
+}
+
+func (l Limit) validate() (Limit, error) {
+	if l.Rate <= 0 || l.Period < time.Millisecond {
+		return l, ErrInvalidLimit
+	}
+	if l.Algorithm == "" {
+		l.Algorithm = SlidingWindow
+	}
+	if l.Burst <= 0 {
+		l.Burst = l.Rate
+	}
+	return l, nil
+}
+
+// capacity is the most requests the limit can allow at once
+func (l Limit) capacity() int {
+	if l.Algorithm == TokenBucket {
+		return l.Burst
+	}
+	return l.Rate
+}
+
+// tokensPerMs is the token bucket refill rate
+func (l Limit) tokensPerMs() float64 {
+	return float64(l.Rate) / float64(l.Period.Milliseconds())
+}
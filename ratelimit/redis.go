@@ -0,0 +1,142 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/google/uuid"
+
+	"github.com/owlify/sparrow/errors"
+)
+
+const defaultKeyPrefix = "ratelimit"
+
+var (
+	ErrMissingPool = errors.NewWithCodef("rate_limit_missing_pool", "redis limiter needs a connection pool")
+)
+
+// redisNow reads the clock of redis in milliseconds so that every instance shares the same time.
+// Effects replication lets the scripts write after reading the non deterministic TIME.
+const redisNow = `
+redis.replicate_commands()
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+`
+
+// slidingWindowScript keeps a sorted set of request timestamps in the window.
+// Returns {allowed, remaining, retry after ms, reset after ms}.
+var slidingWindowScript = redis.NewScript(1, redisNow+`
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+
+if count < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[3])
+	redis.call('PEXPIRE', KEYS[1], window)
+	return {1, limit - count - 1, 0, window}
+end
+
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+local retry = tonumber(oldest[2]) + window - now
+local newest = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+local reset = tonumber(newest[2]) + window - now
+return {0, 0, retry, reset}
+`)
+
+// tokenBucketScript stores the tokens left and the last refill time in a hash.
+// Returns {allowed, remaining, retry after ms, reset after ms}.
+var tokenBucketScript = redis.NewScript(1, redisNow+`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil then
+	tokens = burst
+	ts = now
+end
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate))
+return {allowed, math.floor(tokens), retry, math.ceil((burst - tokens) / rate)}
+`)
+
+type RedisLimiterOpts struct {
+	// Pool is the redis the limits are kept in, it is required
+	Pool *redis.Pool
+	// KeyPrefix namespaces the limiter keys, defaults to ratelimit
+	KeyPrefix string
+}
+
+type redisLimiter struct {
+	pool   *redis.Pool
+	prefix string
+}
+
+// NewRedisLimiter shares limits between every instance of the service, it fails with ErrMissingPool
+// when no pool is given
+func NewRedisLimiter(opts *RedisLimiterOpts) (Limiter, error) {
+	if opts == nil || opts.Pool == nil {
+		return nil, ErrMissingPool
+	}
+
+	limiter := &redisLimiter{pool: opts.Pool, prefix: defaultKeyPrefix}
+	if opts.KeyPrefix != "" {
+		limiter.prefix = opts.KeyPrefix
+	}
+	return limiter, nil
+}
+
+func (l *redisLimiter) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	limit, err := limit.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := l.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	redisKey := fmt.Sprintf("%s:%s:%s", l.prefix, limit.Algorithm, key)
+
+	var reply []int64
+	switch limit.Algorithm {
+	case TokenBucket:
+		rate := strconv.FormatFloat(limit.tokensPerMs(), 'f', -1, 64)
+		reply, err = redis.Int64s(tokenBucketScript.DoContext(ctx, conn, redisKey, rate, limit.Burst))
+	default:
+		reply, err = redis.Int64s(slidingWindowScript.DoContext(ctx, conn, redisKey, limit.Period.Milliseconds(), limit.Rate, uuid.NewString()))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(reply) != 4 {
+		return nil, fmt.Errorf("unexpected rate limit reply %v", reply)
+	}
+
+	return &Result{
+		Allowed:    reply[0] == 1,
+		Limit:      limit.capacity(),
+		Remaining:  int(reply[1]),
+		RetryAfter: time.Duration(reply[2]) * time.Millisecond,
+		ResetAfter: time.Duration(reply[3]) * time.Millisecond,
+	}, nil
+}
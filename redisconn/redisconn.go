@@ -0,0 +1,113 @@
+package redisconn
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/owlify/sparrow/errors"
+)
+
+const (
+	missingAddrCode = "redis_missing_addr"
+)
+
+var (
+	ErrMissingAddr = errors.NewWithCode(missingAddrCode)
+)
+
+// Opts describes how to reach redis, shared by the cache and worker packages.
+// Exactly one of Addr, Sentinel or Cluster should be set. Cluster is only supported by the worker
+// package, the cache rejects it when initialised.
+type Opts struct {
+	Addr     string
+	Username string
+	Password string
+	DB       int
+	// PoolSize caps the open connections, the client default is used when zero. It is ignored for
+	// Cluster, whose pools asynq sizes itself.
+	PoolSize int
+
+	TLS      *TLSOpts
+	Sentinel *SentinelOpts
+	Cluster  *ClusterOpts
+
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+type TLSOpts struct {
+	// CertPath is the CA bundle used to verify the server, the system pool is used when empty
+	CertPath           string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+type SentinelOpts struct {
+	MasterName string
+	Addrs      []string
+	Password   string
+}
+
+type ClusterOpts struct {
+	Addrs        []string
+	MaxRedirects int
+}
+
+func (o *Opts) IsSentinel() bool {
+	return o.Sentinel != nil
+}
+
+func (o *Opts) IsCluster() bool {
+	return o.Cluster != nil
+}
+
+func (o *Opts) Validate() error {
+	switch {
+	case o.IsCluster():
+		if len(o.Cluster.Addrs) == 0 {
+			return errors.NewWithCodef(missingAddrCode, "redis cluster needs at least one address")
+		}
+	case o.IsSentinel():
+		if o.Sentinel.MasterName == "" || len(o.Sentinel.Addrs) == 0 {
+			return errors.NewWithCodef(missingAddrCode, "redis sentinel needs a master name and at least one address")
+		}
+	default:
+		if o.Addr == "" {
+			return ErrMissingAddr
+		}
+	}
+	return nil
+}
+
+// TLSConfig builds the tls configuration, nil when TLS is disabled
+func (o *Opts) TLSConfig() (*tls.Config, error) {
+	if o.TLS == nil {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         o.TLS.ServerName,
+		InsecureSkipVerify: o.TLS.InsecureSkipVerify,
+	}
+
+	if o.TLS.CertPath != "" {
+		caCert, err := os.ReadFile(o.TLS.CertPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+
+		// Create a new certificate pool and add the CA cert
+		caCertPool := x509.NewCertPool()
+		if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
+			return nil, fmt.Errorf("failed to append CA certificate from %s", o.TLS.CertPath)
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+
+	return tlsConfig, nil
+}
@@ -1,10 +1,20 @@
 package web
 
-import "net/http"
+import (
+	"context"
+	stderrors "errors"
+	"net/http"
+
+	"github.com/owlify/sparrow/errors"
+	"github.com/owlify/sparrow/logger"
+	"github.com/owlify/sparrow/request_id"
+)
 
 type errorDetail struct {
-	Code    string `json:"code"`
-	Message string `json:"message"`
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	RequestID string       `json:"request_id,omitempty"`
+	Fields    []FieldError `json:"fields,omitempty"`
 }
 
 type Error struct {
@@ -26,6 +36,8 @@ const (
 	UnauthorizedRequest = "unauthorized"
 	BadRequest          = "bad_request"
 	Forbidden           = "forbidden"
+	NotFound            = "not_found"
+	TooManyRequests     = "too_many_requests"
 	InternalServerError = "internal_server_error"
 )
 
@@ -39,9 +51,15 @@ var (
 	ErrForbiddenRequest = func(desc string, version ApiVersion) Error {
 		return NewError(Forbidden, desc, http.StatusForbidden, version)
 	}
+	ErrNotFoundRequest = func(desc string, version ApiVersion) Error {
+		return NewError(NotFound, desc, http.StatusNotFound, version)
+	}
 	ErrBadRequest = func(desc string, version ApiVersion) Error {
 		return NewError(BadRequest, desc, http.StatusBadRequest, version)
 	}
+	ErrTooManyRequests = func(desc string, version ApiVersion) Error {
+		return NewError(TooManyRequests, desc, http.StatusTooManyRequests, version)
+	}
 	ErrInternalServerError = func(desc string, version ApiVersion) Error {
 		return NewError(InternalServerError, desc, http.StatusInternalServerError, version)
 	}
@@ -50,3 +68,37 @@ var (
 func NewError(errCode string, desc string, httpCode int, version ApiVersion) Error {
 	return Error{Error: errorDetail{Code: errCode, Message: desc}, httpStatus: httpCode, Version: version}
 }
+
+// ErrorResponse turns any error into a web error. Errors with a registered code get its status,
+// the others are logged and become a 500. Wrapped validation errors add their field errors.
+func ErrorResponse(ctx context.Context, err error, version ApiVersion) Response {
+	code := errors.Original(err).Code()
+
+	errorRegistryMu.RLock()
+	mapping, found := errorRegistry[code]
+	errorRegistryMu.RUnlock()
+
+	var webErr Error
+	if found {
+		message := mapping.Message
+		if message == "" {
+			message = err.Error()
+		}
+		webErr = NewError(code, message, mapping.Status, version)
+	} else {
+		logger.E(ctx, err, "[Web] unhandled error", logger.Field("code", code))
+
+		message := err.Error()
+		if hideInternalErrors.Load() {
+			message = internalErrorMessage
+		}
+		webErr = ErrInternalServerError(message, version)
+	}
+
+	var validationErr *ValidationError
+	if stderrors.As(err, &validationErr) {
+		webErr.Error.Fields = validationErr.Fields()
+	}
+	webErr.Error.RequestID = request_id.GetRequestID(ctx)
+	return webErr
+}
@@ -0,0 +1,54 @@
+package web
+
+import (
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/owlify/sparrow/environment"
+)
+
+const internalErrorMessage = "something went wrong"
+
+// ErrorMapping is how errors carrying a code are exposed to clients
+type ErrorMapping struct {
+	Status int
+	// Message replaces the error message in responses when set
+	Message string
+}
+
+var (
+	errorRegistryMu sync.RWMutex
+	errorRegistry   = map[string]ErrorMapping{
+		BadRequest:          {Status: http.StatusBadRequest},
+		UnauthorizedRequest: {Status: http.StatusUnauthorized},
+		Forbidden:           {Status: http.StatusForbidden},
+		NotFound:            {Status: http.StatusNotFound},
+		TooManyRequests:     {Status: http.StatusTooManyRequests},
+	}
+	// hideInternalErrors replaces the message of unknown errors, read on every error response
+	hideInternalErrors atomic.Bool
+)
+
+func init() {
+	hideInternalErrors.Store(hidesInternalErrors(environment.Environment(os.Getenv("ENV"))))
+}
+
+// RegisterErrorCode maps errors created with the code, e.g. by errors.NewWithCode, to the status
+func RegisterErrorCode(code string, mapping ErrorMapping) {
+	errorRegistryMu.Lock()
+	defer errorRegistryMu.Unlock()
+
+	errorRegistry[code] = mapping
+}
+
+// SetEnvironment overrides the environment read from ENV at startup. The message of unmapped errors
+// is only shown in development and test, so that an unset or unknown environment is safe.
+func SetEnvironment(env environment.Environment) {
+	hideInternalErrors.Store(hidesInternalErrors(env))
+}
+
+func hidesInternalErrors(env environment.Environment) bool {
+	return env != environment.DevEnv && env != environment.TestingEnv
+}
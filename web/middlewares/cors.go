@@ -2,20 +2,263 @@ package middlewares
 
 import (
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+
+	"github.com/owlify/sparrow/errors"
+	"github.com/owlify/sparrow/web"
+)
+
+var (
+	defaultCORSMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodPatch, http.MethodOptions}
+	defaultCORSHeaders = []string{"Accept", "Content-Type"}
+	defaultCORS        = &CORSOpts{AllowedOrigins: []string{"*"}, MaxAge: time.Hour, ExposedHeaders: []string{"Content-Length"}}
+
+	errCORSAnyOriginWithCredentials = errors.NewWithCodef("cors_invalid_opts",
+		"cors: AllowCredentials cannot be combined with the * origin, list the allowed origins instead")
 )
 
+type CORSOpts struct {
+	// AllowedOrigins are exact origins such as https://app.example.com, wildcard subdomains such as
+	// https://*.example.com, or * for any origin
+	AllowedOrigins []string
+	// AllowedOriginPatterns are matched against the origin and should be anchored, e.g. ^https://pr-\d+\.example\.com$
+	AllowedOriginPatterns []*regexp.Regexp
+	// AllowedMethods default to GET, POST, PUT, DELETE, PATCH and OPTIONS
+	AllowedMethods []string
+	// AllowedHeaders default to Accept and Content-Type, * allows any requested header
+	AllowedHeaders []string
+	ExposedHeaders []string
+	// AllowCredentials lets browsers send cookies and authorization headers, it cannot be combined
+	// with the * origin. The request origin is echoed as browsers reject credentials for any origin.
+	AllowCredentials bool
+	// MaxAge is how long browsers cache the preflight response
+	MaxAge time.Duration
+}
+
+type cors struct {
+	opts           *CORSOpts
+	anyOrigin      bool
+	anyHeader      bool
+	origins        map[string]struct{}
+	wildcards      [][2]string
+	methods        map[string]struct{}
+	headers        map[string]struct{}
+	allowedMethods string
+	allowedHeaders string
+	exposedHeaders string
+}
+
+// CORS allows requests from any origin without credentials, see CORSWithOpts to restrict the origins
+// or allow credentials
 func CORS(next httprouter.Handle) httprouter.Handle {
-	return func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
-		writer.Header().Set("Access-Control-Allow-Origin", "*")
-		writer.Header().Set("Content-Type", "application/json")
-		writer.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type")
-		writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, PATCH, OPTIONS")
-		writer.Header().Set("Access-Control-Max-Age", "3600")
-		writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		writer.Header().Set("Access-Control-Expose-Headers", "Content-Length")
+	// defaultCORS does not allow credentials so it is always valid
+	cors, _ := CORSWithOpts(defaultCORS)
+	return cors(next)
+}
+
+// CORSWithOpts sets the CORS headers of allowed origins and answers preflight requests without calling
+// the endpoint. Preflights only reach the middleware of routes registered for OPTIONS, serve the others
+// by setting CORSPreflight as the router GlobalOPTIONS handler.
+func CORSWithOpts(opts *CORSOpts) (web.Middleware, error) {
+	c, err := newCORS(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+			if isPreflight(r) {
+				c.preflight(w, r)
+				return
+			}
+
+			c.actual(w, r)
+			next(w, r, params)
+		}
+	}, nil
+}
+
+// CORSPreflight answers the preflight requests of every route, e.g. server.Router().GlobalOPTIONS
+func CORSPreflight(opts *CORSOpts) (http.Handler, error) {
+	c, err := newCORS(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isPreflight(r) {
+			c.preflight(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}), nil
+}
+
+// newCORS fails when any origin is allowed with credentials, every site could then make credentialed
+// requests on behalf of the user
+func newCORS(corsOpts *CORSOpts) (*cors, error) {
+	// copied so that neither the caller's opts nor defaultCORS get the defaults
+	opts := *corsOpts
+	if opts.AllowCredentials {
+		for _, origin := range opts.AllowedOrigins {
+			if origin == "*" {
+				return nil, errCORSAnyOriginWithCredentials
+			}
+		}
+	}
+
+	if len(opts.AllowedMethods) == 0 {
+		opts.AllowedMethods = defaultCORSMethods
+	}
+	if len(opts.AllowedHeaders) == 0 {
+		opts.AllowedHeaders = defaultCORSHeaders
+	}
+
+	c := &cors{
+		opts:           &opts,
+		origins:        map[string]struct{}{},
+		methods:        map[string]struct{}{},
+		headers:        map[string]struct{}{},
+		allowedMethods: strings.Join(opts.AllowedMethods, ", "),
+		allowedHeaders: strings.Join(opts.AllowedHeaders, ", "),
+		exposedHeaders: strings.Join(opts.ExposedHeaders, ", "),
+	}
+	for _, origin := range opts.AllowedOrigins {
+		origin = strings.ToLower(origin)
+		switch {
+		case origin == "*":
+			c.anyOrigin = true
+		case strings.Contains(origin, "*"):
+			prefix, suffix, _ := strings.Cut(origin, "*")
+			c.wildcards = append(c.wildcards, [2]string{prefix, suffix})
+		default:
+			c.origins[origin] = struct{}{}
+		}
+	}
+	for _, method := range opts.AllowedMethods {
+		c.methods[strings.ToUpper(method)] = struct{}{}
+	}
+	for _, header := range opts.AllowedHeaders {
+		if header == "*" {
+			c.anyHeader = true
+		}
+		c.headers[http.CanonicalHeaderKey(header)] = struct{}{}
+	}
+	return c, nil
+}
+
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// preflight responds 204, without the CORS headers when the origin, method or headers are not allowed
+func (c *cors) preflight(w http.ResponseWriter, r *http.Request) {
+	headers := w.Header()
+	headers.Add("Vary", "Origin")
+	headers.Add("Vary", "Access-Control-Request-Method")
+	headers.Add("Vary", "Access-Control-Request-Headers")
+
+	origin := r.Header.Get("Origin")
+	requestedHeaders := parseHeaderList(r.Header.Get("Access-Control-Request-Headers"))
+	if !c.originAllowed(origin) || !c.methodAllowed(r.Header.Get("Access-Control-Request-Method")) ||
+		!c.headersAllowed(requestedHeaders) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	c.setOrigin(w, origin)
+	headers.Set("Access-Control-Allow-Methods", c.allowedMethods)
+	if c.anyHeader {
+		if len(requestedHeaders) > 0 {
+			headers.Set("Access-Control-Allow-Headers", strings.Join(requestedHeaders, ", "))
+		}
+	} else {
+		headers.Set("Access-Control-Allow-Headers", c.allowedHeaders)
+	}
+	if c.opts.MaxAge > 0 {
+		headers.Set("Access-Control-Max-Age", strconv.Itoa(int(c.opts.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// actual sets the CORS headers of a non preflight request from an allowed origin
+func (c *cors) actual(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Origin")
+
+	origin := r.Header.Get("Origin")
+	if origin == "" || !c.originAllowed(origin) {
+		return
+	}
+
+	c.setOrigin(w, origin)
+	if c.exposedHeaders != "" {
+		w.Header().Set("Access-Control-Expose-Headers", c.exposedHeaders)
+	}
+}
+
+func (c *cors) setOrigin(w http.ResponseWriter, origin string) {
+	if c.anyOrigin {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.opts.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (c *cors) originAllowed(origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+
+	lowerOrigin := strings.ToLower(origin)
+	if _, ok := c.origins[lowerOrigin]; ok {
+		return true
+	}
+	for _, wildcard := range c.wildcards {
+		if len(lowerOrigin) > len(wildcard[0])+len(wildcard[1]) &&
+			strings.HasPrefix(lowerOrigin, wildcard[0]) && strings.HasSuffix(lowerOrigin, wildcard[1]) {
+			return true
+		}
+	}
+	for _, pattern := range c.opts.AllowedOriginPatterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *cors) methodAllowed(method string) bool {
+	_, ok := c.methods[strings.ToUpper(method)]
+	return ok
+}
+
+func (c *cors) headersAllowed(headers []string) bool {
+	if c.anyHeader {
+		return true
+	}
+	for _, header := range headers {
+		if _, ok := c.headers[http.CanonicalHeaderKey(header)]; !ok {
+			return false
+		}
+	}
+	return true
+}
 
-		next(writer, request, params)
+func parseHeaderList(list string) []string {
+	var headers []string
+	for _, header := range strings.Split(list, ",") {
+		if header = strings.TrimSpace(header); header != "" {
+			headers = append(headers, header)
+		}
 	}
+	return headers
 }
@@ -0,0 +1,182 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func preflightRequest(origin, method, headers string) *http.Request {
+	r := httptest.NewRequest(http.MethodOptions, "/orders", nil)
+	r.Header.Set("Origin", origin)
+	r.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		r.Header.Set("Access-Control-Request-Headers", headers)
+	}
+	return r
+}
+
+func originRequest(origin string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	r.Header.Set("Origin", origin)
+	return r
+}
+
+func TestCORSRejectsAnyOriginWithCredentials(t *testing.T) {
+	opts := &CORSOpts{AllowedOrigins: []string{"https://app.example.com", "*"}, AllowCredentials: true}
+
+	if _, err := CORSWithOpts(opts); err == nil {
+		t.Error("CORSWithOpts accepted any origin with credentials")
+	}
+	if _, err := CORSPreflight(opts); err == nil {
+		t.Error("CORSPreflight accepted any origin with credentials")
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	w := serve(CORS, originRequest("https://anything.example.org"))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want *", origin)
+	}
+	if credentials := w.Header().Get("Access-Control-Allow-Credentials"); credentials != "" {
+		t.Errorf("Access-Control-Allow-Credentials = %q, want none", credentials)
+	}
+}
+
+func TestCORSAllowedOrigins(t *testing.T) {
+	cors, err := CORSWithOpts(&CORSOpts{
+		AllowedOrigins:        []string{"https://app.example.com", "https://*.example.net"},
+		AllowedOriginPatterns: []*regexp.Regexp{regexp.MustCompile(`^https://pr-\d+\.example\.dev$`)},
+		AllowCredentials:      true,
+		ExposedHeaders:        []string{"X-Request-Id"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]bool{
+		"https://app.example.com":      true,
+		"https://APP.example.com":      true,
+		"https://admin.example.net":    true,
+		"https://pr-12.example.dev":    true,
+		"https://evil.example.com":     false,
+		"https://example.net":          false,
+		"https://pr-12.example.dev.io": false,
+		"http://app.example.com":       false,
+	}
+	for origin, allowed := range tests {
+		t.Run(origin, func(t *testing.T) {
+			w := serve(cors, originRequest(origin))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			wantOrigin, wantCredentials, wantExposed := "", "", ""
+			if allowed {
+				wantOrigin, wantCredentials, wantExposed = origin, "true", "X-Request-Id"
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, wantOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); got != wantCredentials {
+				t.Errorf("Access-Control-Allow-Credentials = %q, want %q", got, wantCredentials)
+			}
+			if got := w.Header().Get("Access-Control-Expose-Headers"); got != wantExposed {
+				t.Errorf("Access-Control-Expose-Headers = %q, want %q", got, wantExposed)
+			}
+			if vary := w.Header().Values("Vary"); len(vary) == 0 || vary[0] != "Origin" {
+				t.Errorf("Vary = %v, want Origin", vary)
+			}
+		})
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	cors, err := CORSWithOpts(&CORSOpts{
+		AllowedOrigins: []string{"https://app.example.com"},
+		AllowedHeaders: []string{"Content-Type", "Authorization"},
+		MaxAge:         10 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		request *http.Request
+		allowed bool
+	}{
+		"allowed":            {request: preflightRequest("https://app.example.com", http.MethodPost, "content-type, authorization"), allowed: true},
+		"origin not allowed": {request: preflightRequest("https://evil.example.com", http.MethodPost, "")},
+		"method not allowed": {request: preflightRequest("https://app.example.com", "TRACE", "")},
+		"header not allowed": {request: preflightRequest("https://app.example.com", http.MethodPost, "X-Custom")},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			called := false
+			w := httptest.NewRecorder()
+			cors(func(http.ResponseWriter, *http.Request, httprouter.Params) { called = true })(w, test.request, nil)
+
+			if called {
+				t.Error("the preflight reached the endpoint")
+			}
+			if w.Code != http.StatusNoContent {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusNoContent)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); (got != "") != test.allowed {
+				t.Errorf("Access-Control-Allow-Origin = %q, allowed = %v", got, test.allowed)
+			}
+			if !test.allowed {
+				return
+			}
+			if got := w.Header().Get("Access-Control-Allow-Methods"); got != "GET, POST, PUT, DELETE, PATCH, OPTIONS" {
+				t.Errorf("Access-Control-Allow-Methods = %q", got)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Headers"); got != "Content-Type, Authorization" {
+				t.Errorf("Access-Control-Allow-Headers = %q", got)
+			}
+			if got := w.Header().Get("Access-Control-Max-Age"); got != "600" {
+				t.Errorf("Access-Control-Max-Age = %q, want 600", got)
+			}
+		})
+	}
+}
+
+func TestCORSPreflightHandler(t *testing.T) {
+	handler, err := CORSPreflight(&CORSOpts{AllowedOrigins: []string{"https://app.example.com"}, AllowedHeaders: []string{"*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, preflightRequest("https://app.example.com", http.MethodDelete, "X-Custom, X-Other"))
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Headers"); got != "X-Custom, X-Other" {
+		t.Errorf("Access-Control-Allow-Headers = %q, want the requested headers", got)
+	}
+}
+
+func TestCORSDoesNotMutateOpts(t *testing.T) {
+	opts := &CORSOpts{AllowedOrigins: []string{"https://app.example.com"}}
+	if _, err := CORSWithOpts(opts); err != nil {
+		t.Fatal(err)
+	}
+	CORS(nil)
+
+	if opts.AllowedMethods != nil || opts.AllowedHeaders != nil {
+		t.Errorf("opts were given defaults: %+v", opts)
+	}
+	if defaultCORS.AllowedMethods != nil || defaultCORS.AllowedHeaders != nil {
+		t.Errorf("defaultCORS was given defaults: %+v", defaultCORS)
+	}
+}
@@ -0,0 +1,10 @@
+package middlewares
+
+import (
+	"github.com/owlify/sparrow/web"
+)
+
+// Defaults are the middlewares most services wrap every endpoint with, meant for web.ServerOpts
+func Defaults() []web.Middleware {
+	return []web.Middleware{RequestID, Logger, PanicHandler}
+}
@@ -0,0 +1,205 @@
+package middlewares
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/imroc/req/v3"
+
+	"github.com/owlify/sparrow/errors"
+	"github.com/owlify/sparrow/logger"
+)
+
+const (
+	defaultJWKSRefreshInterval = time.Hour
+	// minJWKSRefetchInterval bounds the refetches caused by tokens signed with unknown key ids
+	minJWKSRefetchInterval = time.Minute
+)
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwks caches the signing keys published at the url, refetched every interval or on an unknown key id
+type jwks struct {
+	url      string
+	client   *req.Client
+	interval time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	// refreshing is closed once the running fetch completes, nil when none runs
+	refreshing chan struct{}
+}
+
+func newJWKS(url string, client *req.Client, interval time.Duration) *jwks {
+	return &jwks{url: url, client: client, interval: interval}
+}
+
+// key returns the key with the id, or the only key when the token has no key id. The keys are fetched
+// without holding the lock, a stale key keeps verifying tokens while they are refetched.
+func (s *jwks) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	key, found := s.lookup(kid)
+	stale := time.Since(s.fetchedAt) > s.interval
+	if found && !stale {
+		s.mu.Unlock()
+		return key, nil
+	}
+
+	if s.refreshing == nil && (stale || time.Since(s.fetchedAt) > minJWKSRefetchInterval) {
+		// failed fetches are not retried before minJWKSRefetchInterval either
+		s.fetchedAt = time.Now()
+		s.refreshing = make(chan struct{})
+		go s.refresh(context.WithoutCancel(ctx), s.refreshing)
+	}
+	refreshing := s.refreshing
+	s.mu.Unlock()
+
+	if found {
+		return key, nil
+	}
+	if refreshing != nil {
+		select {
+		case <-refreshing:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key, found := s.lookup(kid); found {
+		return key, nil
+	}
+	return nil, errors.NewWithCodef("jwks_key_not_found", "no signing key with id %q", kid)
+}
+
+func (s *jwks) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	key, found := s.keys[kid]
+	return key, found
+}
+
+// refresh replaces the keys once fetched, the previous keys are kept when the fetch fails. The fetch
+// is bounded by jwksFetchTimeout as it outlives the request, a client without a timeout would
+// otherwise keep refreshing set forever.
+func (s *jwks) refresh(ctx context.Context, done chan struct{}) {
+	ctx, cancel := context.WithTimeout(ctx, jwksFetchTimeout)
+	defer cancel()
+
+	keys, err := s.fetch(ctx)
+	if err != nil {
+		logger.E(ctx, err, "[JWT] failed to fetch JWKS", logger.Field("url", s.url))
+	}
+
+	s.mu.Lock()
+	if err == nil {
+		s.keys = keys
+	}
+	s.refreshing = nil
+	s.mu.Unlock()
+	close(done)
+}
+
+func (s *jwks) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	resp, err := s.client.R().SetContext(ctx).Get(s.url)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.IsSuccessState() {
+		return nil, fmt.Errorf("jwks responded with status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(resp.Bytes(), &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			logger.W(ctx, "[JWT] skipping invalid JWKS key", logger.Field("kid", k.Kid), logger.Field("error", err.Error()))
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		// the exponents crypto/rsa verifies with, odd and within an int32
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 || e.Bit(0) == 0 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(decoded), nil
+}
@@ -0,0 +1,371 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/imroc/req/v3"
+	"github.com/julienschmidt/httprouter"
+
+	sparrowhttp "github.com/owlify/sparrow/http"
+	"github.com/owlify/sparrow/logger"
+	"github.com/owlify/sparrow/web"
+)
+
+const (
+	defaultScopesClaim = "scope"
+	defaultRolesClaim  = "roles"
+	jwksFetchTimeout   = 5 * time.Second
+)
+
+var jwtHashes = map[string]crypto.Hash{
+	"256": crypto.SHA256,
+	"384": crypto.SHA384,
+	"512": crypto.SHA512,
+}
+
+// jwtCurves are the curves the ES algorithms are defined for, a key on another curve is rejected
+var jwtCurves = map[string]string{
+	"ES256": "P-256",
+	"ES384": "P-384",
+	"ES512": "P-521",
+}
+
+type JWTOpts struct {
+	// HMACSecret verifies the HS256, HS384 and HS512 tokens
+	HMACSecret []byte
+	// PublicKeys verify the RS and ES tokens by key id, *rsa.PublicKey or *ecdsa.PublicKey
+	PublicKeys map[string]crypto.PublicKey
+	// JWKSURL publishes the RS and ES keys of the issuer, fetched with the sparrow HTTP client
+	JWKSURL string
+	// JWKSRefreshInterval defaults to 1h, unknown key ids refetch the keys at most once a minute
+	JWKSRefreshInterval time.Duration
+	// HTTPClient fetches the JWKS, defaults to a retryable client with a 5s timeout
+	HTTPClient *req.Client
+	// Algorithms restrict the accepted algorithms, defaults to those of the configured keys
+	Algorithms []string
+
+	// Issuer and Audience are checked when set, the token must be issued for one of the audiences
+	Issuer   string
+	Audience []string
+	// Leeway tolerates clock drift when checking exp and nbf
+	Leeway time.Duration
+
+	// ScopesClaim and RolesClaim default to scope and roles, nested claims are reached by a dotted
+	// path such as realm_access.roles
+	ScopesClaim string
+	RolesClaim  string
+
+	// Version defaults to web.V1Api
+	Version web.ApiVersion
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtVerifier struct {
+	opts       *JWTOpts
+	algorithms map[string]struct{}
+	jwks       *jwks
+}
+
+// JWT authenticates the bearer token of the request and puts the caller in the context, see
+// web.Request.Principal. Invalid or missing tokens are rejected with 401.
+func JWT(opts *JWTOpts) web.Middleware {
+	verifier := newJWTVerifier(opts)
+	// the opts with the defaults applied
+	opts = verifier.opts
+
+	return func(next httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+			token, found := bearerToken(r)
+			if !found {
+				unauthenticated(w, opts.Version, "missing bearer token", "")
+				return
+			}
+
+			claims, err := verifier.verify(r.Context(), token)
+			if err != nil {
+				logger.W(r.Context(), "[JWT] invalid token", logger.Field("error", err.Error()))
+				unauthenticated(w, opts.Version, "invalid bearer token", "invalid_token")
+				return
+			}
+
+			principal := &web.Principal{
+				Subject: claims.String("sub"),
+				Scopes:  claims.Strings(opts.ScopesClaim),
+				Roles:   claims.Strings(opts.RolesClaim),
+				Claims:  claims,
+			}
+			if opts.ScopesClaim == defaultScopesClaim && len(principal.Scopes) == 0 {
+				// some issuers, such as Azure AD, name the claim scp
+				principal.Scopes = claims.Strings("scp")
+			}
+			next(w, r.WithContext(web.WithPrincipal(r.Context(), principal)), params)
+		}
+	}
+}
+
+// RequireScopes rejects with 403 the callers missing any of the scopes, use it after JWT
+func RequireScopes(version web.ApiVersion, scopes ...string) web.Middleware {
+	return requirePrincipal(version, func(principal *web.Principal) bool {
+		for _, scope := range scopes {
+			if !principal.HasScope(scope) {
+				return false
+			}
+		}
+		return true
+	}, "insufficient scope", "insufficient_scope")
+}
+
+// RequireRoles rejects with 403 the callers having none of the roles, use it after JWT
+func RequireRoles(version web.ApiVersion, roles ...string) web.Middleware {
+	return requirePrincipal(version, func(principal *web.Principal) bool {
+		for _, role := range roles {
+			if principal.HasRole(role) {
+				return true
+			}
+		}
+		return false
+	}, "insufficient role", "")
+}
+
+// requirePrincipal challenges with the bearer error when set, roles are not an OAuth error
+func requirePrincipal(version web.ApiVersion, allowed func(*web.Principal) bool, reason string, bearerError string) web.Middleware {
+	return func(next httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+			principal := web.PrincipalFromContext(r.Context())
+			if principal == nil {
+				unauthenticated(w, version, "missing bearer token", "")
+				return
+			}
+			if !allowed(principal) {
+				if bearerError != "" {
+					w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="%s"`, bearerError))
+				}
+				web.WriteJsonResponse(w, web.ErrForbiddenRequest(reason, version))
+				return
+			}
+			next(w, r, params)
+		}
+	}
+}
+
+func unauthenticated(w http.ResponseWriter, version web.ApiVersion, message string, bearerError string) {
+	challenge := "Bearer"
+	if bearerError != "" {
+		challenge = fmt.Sprintf(`Bearer error="%s"`, bearerError)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	web.WriteJsonResponse(w, web.ErrUnauthenticatedRequest(message, version))
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+func newJWTVerifier(jwtOpts *JWTOpts) *jwtVerifier {
+	// copied so that the defaults are not applied to the caller's opts
+	opts := *jwtOpts
+	if opts.ScopesClaim == "" {
+		opts.ScopesClaim = defaultScopesClaim
+	}
+	if opts.RolesClaim == "" {
+		opts.RolesClaim = defaultRolesClaim
+	}
+	if opts.Version == "" {
+		opts.Version = web.V1Api
+	}
+	if opts.JWKSRefreshInterval <= 0 {
+		opts.JWKSRefreshInterval = defaultJWKSRefreshInterval
+	}
+
+	algorithms := opts.Algorithms
+	if len(algorithms) == 0 {
+		if len(opts.HMACSecret) > 0 {
+			algorithms = append(algorithms, "HS256", "HS384", "HS512")
+		}
+		if len(opts.PublicKeys) > 0 || opts.JWKSURL != "" {
+			algorithms = append(algorithms, "RS256", "RS384", "RS512", "ES256", "ES384", "ES512")
+		}
+	}
+
+	v := &jwtVerifier{opts: &opts, algorithms: map[string]struct{}{}}
+	for _, alg := range algorithms {
+		v.algorithms[alg] = struct{}{}
+	}
+	if opts.JWKSURL != "" {
+		client := opts.HTTPClient
+		if client == nil {
+			client = sparrowhttp.RetryableHTTPClient(sparrowhttp.HttpClientOpts{Timeout: jwksFetchTimeout, Retries: 1})
+		}
+		v.jwks = newJWKS(opts.JWKSURL, client, opts.JWKSRefreshInterval)
+	}
+	return v
+}
+
+// verify checks the signature and the registered claims of the compact serialized token
+func (v *jwtVerifier) verify(ctx context.Context, token string) (web.Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed header: %w", err)
+	}
+	if _, allowed := v.algorithms[header.Alg]; !allowed {
+		return nil, fmt.Errorf("algorithm %q is not allowed", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature: %w", err)
+	}
+	if err := v.verifySignature(ctx, header, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	var claims web.Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed claims: %w", err)
+	}
+	return claims, v.verifyClaims(claims)
+}
+
+func (v *jwtVerifier) verifySignature(ctx context.Context, header jwtHeader, signed []byte, signature []byte) error {
+	if len(header.Alg) != 5 {
+		return fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+	hash, supported := jwtHashes[header.Alg[2:]]
+	if !supported {
+		return fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+	hasher := hash.New()
+	hasher.Write(signed)
+	digest := hasher.Sum(nil)
+
+	if strings.HasPrefix(header.Alg, "HS") {
+		mac := hmac.New(hash.New, v.opts.HMACSecret)
+		mac.Write(signed)
+		if len(v.opts.HMACSecret) == 0 || !hmac.Equal(mac.Sum(nil), signature) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	}
+
+	key, err := v.publicKey(ctx, header.Kid)
+	if err != nil {
+		return err
+	}
+
+	switch header.Alg[:2] {
+	case "RS":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key %q is not an RSA key", header.Kid)
+		}
+		return rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature)
+	case "ES":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key %q is not an EC key", header.Kid)
+		}
+		if curve := jwtCurves[header.Alg]; ecKey.Curve.Params().Name != curve {
+			return fmt.Errorf("key %q is not a %s key", header.Kid, curve)
+		}
+		// ES signatures are the fixed size r and s concatenated
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+}
+
+func (v *jwtVerifier) publicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if key, found := v.opts.PublicKeys[kid]; found {
+		return key, nil
+	}
+	if kid == "" && len(v.opts.PublicKeys) == 1 && v.jwks == nil {
+		for _, key := range v.opts.PublicKeys {
+			return key, nil
+		}
+	}
+	if v.jwks == nil {
+		return nil, fmt.Errorf("no signing key with id %q", kid)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, jwksFetchTimeout)
+	defer cancel()
+	return v.jwks.key(ctx, kid)
+}
+
+// verifyClaims checks exp, which is required, nbf, iss and aud
+func (v *jwtVerifier) verifyClaims(claims web.Claims) error {
+	now := time.Now()
+
+	exp, found := claims.Int64("exp")
+	if !found {
+		return fmt.Errorf("missing exp claim")
+	}
+	if now.After(time.Unix(exp, 0).Add(v.opts.Leeway)) {
+		return fmt.Errorf("token expired")
+	}
+	if nbf, found := claims.Int64("nbf"); found && now.Add(v.opts.Leeway).Before(time.Unix(nbf, 0)) {
+		return fmt.Errorf("token not valid yet")
+	}
+
+	if v.opts.Issuer != "" && claims.String("iss") != v.opts.Issuer {
+		return fmt.Errorf("unexpected issuer %q", claims.String("iss"))
+	}
+
+	if len(v.opts.Audience) > 0 {
+		audiences := claims.Strings("aud")
+		for _, audience := range v.opts.Audience {
+			for _, tokenAudience := range audiences {
+				if audience == tokenAudience {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("unexpected audience %v", audiences)
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(decoded))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
@@ -0,0 +1,379 @@
+package middlewares
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/imroc/req/v3"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/owlify/sparrow/web"
+)
+
+var hmacSecret = []byte("jwt-test-secret")
+
+func encodeSegment(t *testing.T, v interface{}) string {
+	t.Helper()
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+func hs256Token(t *testing.T, header map[string]interface{}, claims map[string]interface{}) string {
+	t.Helper()
+	if header == nil {
+		header = map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+	}
+	signed := encodeSegment(t, header) + "." + encodeSegment(t, claims)
+	mac := hmac.New(sha256.New, hmacSecret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func rs256Token(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	signed := encodeSegment(t, map[string]interface{}{"alg": "RS256", "kid": kid}) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func es256Token(t *testing.T, key *ecdsa.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+	signed := encodeSegment(t, map[string]interface{}{"alg": "ES256"}) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := (key.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*size)
+	r.FillBytes(signature[:size])
+	s.FillBytes(signature[size:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub":   "user-1",
+		"iss":   "https://issuer.example.com",
+		"aud":   "orders",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": "orders:read orders:write",
+		"roles": []string{"admin"},
+	}
+}
+
+func withClaim(claims map[string]interface{}, name string, value interface{}) map[string]interface{} {
+	claims[name] = value
+	return claims
+}
+
+func withoutClaim(claims map[string]interface{}, name string) map[string]interface{} {
+	delete(claims, name)
+	return claims
+}
+
+func bearerRequest(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestJWTVerifyRejections(t *testing.T) {
+	verifier := newJWTVerifier(&JWTOpts{
+		HMACSecret: hmacSecret,
+		Algorithms: []string{"HS256"},
+		Issuer:     "https://issuer.example.com",
+		Audience:   []string{"orders"},
+	})
+
+	tests := map[string]string{
+		"alg none":          hs256Token(t, map[string]interface{}{"alg": "none"}, validClaims()),
+		"alg not allowed":   hs256Token(t, map[string]interface{}{"alg": "HS512"}, validClaims()),
+		"expired":           hs256Token(t, nil, withClaim(validClaims(), "exp", time.Now().Add(-time.Minute).Unix())),
+		"missing exp":       hs256Token(t, nil, withoutClaim(validClaims(), "exp")),
+		"not valid yet":     hs256Token(t, nil, withClaim(validClaims(), "nbf", time.Now().Add(time.Hour).Unix())),
+		"other issuer":      hs256Token(t, nil, withClaim(validClaims(), "iss", "https://evil.example.com")),
+		"other audience":    hs256Token(t, nil, withClaim(validClaims(), "aud", []string{"payments"})),
+		"missing audience":  hs256Token(t, nil, withoutClaim(validClaims(), "aud")),
+		"tampered claims":   strings.Replace(hs256Token(t, nil, validClaims()), ".", "."+encodeSegment(t, validClaims())[:4], 1),
+		"malformed":         "not-a-token",
+		"altered signature": hs256Token(t, nil, validClaims()) + "x",
+	}
+	for name, token := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := verifier.verify(context.Background(), token); err == nil {
+				t.Error("verify succeeded, want an error")
+			}
+		})
+	}
+
+	if _, err := verifier.verify(context.Background(), hs256Token(t, nil, validClaims())); err != nil {
+		t.Errorf("verify of a valid token: %v", err)
+	}
+}
+
+func TestJWTLeeway(t *testing.T) {
+	verifier := newJWTVerifier(&JWTOpts{HMACSecret: hmacSecret, Leeway: time.Minute})
+
+	expired := hs256Token(t, nil, withClaim(validClaims(), "exp", time.Now().Add(-30*time.Second).Unix()))
+	if _, err := verifier.verify(context.Background(), expired); err != nil {
+		t.Errorf("token expired within the leeway: %v", err)
+	}
+	notYet := hs256Token(t, nil, withClaim(validClaims(), "nbf", time.Now().Add(30*time.Second).Unix()))
+	if _, err := verifier.verify(context.Background(), notYet); err != nil {
+		t.Errorf("token valid within the leeway: %v", err)
+	}
+}
+
+func TestJWTMiddleware(t *testing.T) {
+	auth := JWT(&JWTOpts{HMACSecret: hmacSecret})
+
+	var principal *web.Principal
+	handler := auth(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		principal = web.PrincipalFromContext(r.Context())
+	})
+
+	w := httptest.NewRecorder()
+	handler(w, bearerRequest(hs256Token(t, nil, validClaims())), nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if principal == nil || principal.Subject != "user-1" || !principal.HasScope("orders:write") || !principal.HasRole("admin") {
+		t.Errorf("principal = %+v", principal)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/orders", nil), nil)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Errorf("missing token: status = %d, challenge = %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, bearerRequest(hs256Token(t, nil, withClaim(validClaims(), "exp", time.Now().Add(-time.Hour).Unix()))), nil)
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Header().Get("WWW-Authenticate"), "invalid_token") {
+		t.Errorf("expired token: status = %d, challenge = %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+}
+
+func TestRequireScopesAndRoles(t *testing.T) {
+	auth := JWT(&JWTOpts{HMACSecret: hmacSecret})
+	chain := func(middleware web.Middleware) web.Middleware {
+		return func(next httprouter.Handle) httprouter.Handle { return auth(middleware(next)) }
+	}
+
+	tests := map[string]struct {
+		middleware web.Middleware
+		status     int
+		challenge  string
+	}{
+		"scope granted":   {middleware: RequireScopes(web.V1Api, "orders:read"), status: http.StatusOK},
+		"scope missing":   {middleware: RequireScopes(web.V1Api, "orders:delete"), status: http.StatusForbidden, challenge: `Bearer error="insufficient_scope"`},
+		"one role held":   {middleware: RequireRoles(web.V1Api, "viewer", "admin"), status: http.StatusOK},
+		"no role is held": {middleware: RequireRoles(web.V1Api, "viewer"), status: http.StatusForbidden},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := serve(chain(test.middleware), bearerRequest(hs256Token(t, nil, validClaims())))
+			if w.Code != test.status {
+				t.Errorf("status = %d, want %d", w.Code, test.status)
+			}
+			if challenge := w.Header().Get("WWW-Authenticate"); challenge != test.challenge {
+				t.Errorf("challenge = %q, want %q", challenge, test.challenge)
+			}
+		})
+	}
+}
+
+func TestJWTDoesNotMutateOpts(t *testing.T) {
+	opts := &JWTOpts{HMACSecret: hmacSecret}
+	JWT(opts)
+
+	if opts.ScopesClaim != "" || opts.RolesClaim != "" || opts.Version != "" || opts.JWKSRefreshInterval != 0 {
+		t.Errorf("opts were given defaults: %+v", opts)
+	}
+}
+
+func TestJWTRejectsCurveOfOtherAlgorithm(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := newJWTVerifier(&JWTOpts{PublicKeys: map[string]crypto.PublicKey{"": &p256.PublicKey}, Algorithms: []string{"ES256"}})
+	if _, err := verifier.verify(context.Background(), es256Token(t, p256, validClaims())); err != nil {
+		t.Fatalf("verify with a P-256 key: %v", err)
+	}
+
+	// a valid P-384 signature of the ES256 digest, only the curve check rejects it
+	signed := encodeSegment(t, map[string]interface{}{"alg": "ES256"}) + "." + encodeSegment(t, validClaims())
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, p384, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := make([]byte, 96)
+	r.FillBytes(signature[:48])
+	s.FillBytes(signature[48:])
+	token := signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+	verifier = newJWTVerifier(&JWTOpts{PublicKeys: map[string]crypto.PublicKey{"": &p384.PublicKey}, Algorithms: []string{"ES256"}})
+	if _, err := verifier.verify(context.Background(), token); err == nil {
+		t.Error("verify of an ES256 token with a P-384 key succeeded")
+	}
+}
+
+func TestJWKRejectsInvalidRSAExponent(t *testing.T) {
+	n := base64.RawURLEncoding.EncodeToString(big.NewInt(1).Lsh(big.NewInt(1), 2048).Bytes())
+	tests := map[string]string{
+		"one":       base64.RawURLEncoding.EncodeToString([]byte{1}),
+		"even":      base64.RawURLEncoding.EncodeToString([]byte{1, 0, 0}),
+		"too large": base64.RawURLEncoding.EncodeToString(big.NewInt(1<<33 + 1).Bytes()),
+		"empty":     "",
+	}
+	for name, e := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := (jwk{Kty: "RSA", N: n, E: e}).publicKey(); err == nil {
+				t.Error("publicKey succeeded, want an error")
+			}
+		})
+	}
+
+	if _, err := (jwk{Kty: "RSA", N: n, E: "AQAB"}).publicKey(); err != nil {
+		t.Errorf("publicKey with the exponent 65537: %v", err)
+	}
+}
+
+// jwksServer publishes the public keys of the signing keys, counting the fetches
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    map[string]*rsa.PrivateKey
+	fetches int32
+}
+
+func newJWKSServer(t *testing.T) *jwksServer {
+	s := &jwksServer{keys: map[string]*rsa.PrivateKey{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.fetches, 1)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		set := struct {
+			Keys []jwk `json:"keys"`
+		}{}
+		for kid, key := range s.keys {
+			set.Keys = append(set.Keys, jwk{
+				Kty: "RSA",
+				Kid: kid,
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		_ = json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) rotate(t *testing.T, kid string) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	s.keys[kid] = key
+	s.mu.Unlock()
+	return key
+}
+
+func TestJWKSRefetchesUnknownKeyID(t *testing.T) {
+	server := newJWKSServer(t)
+	first := server.rotate(t, "first")
+	verifier := newJWTVerifier(&JWTOpts{JWKSURL: server.URL, HTTPClient: req.C()})
+	ctx := context.Background()
+
+	if _, err := verifier.verify(ctx, rs256Token(t, first, "first", validClaims())); err != nil {
+		t.Fatalf("verify with the published key: %v", err)
+	}
+	if _, err := verifier.verify(ctx, rs256Token(t, first, "first", validClaims())); err != nil {
+		t.Fatalf("verify with the cached key: %v", err)
+	}
+	if fetches := atomic.LoadInt32(&server.fetches); fetches != 1 {
+		t.Fatalf("fetches = %d, want 1", fetches)
+	}
+
+	// the issuer rotates its key, the unknown key id refetches the set
+	second := server.rotate(t, "second")
+	verifier.jwks.mu.Lock()
+	verifier.jwks.fetchedAt = time.Now().Add(-2 * minJWKSRefetchInterval)
+	verifier.jwks.mu.Unlock()
+
+	if _, err := verifier.verify(ctx, rs256Token(t, second, "second", validClaims())); err != nil {
+		t.Fatalf("verify with the rotated key: %v", err)
+	}
+	if fetches := atomic.LoadInt32(&server.fetches); fetches != 2 {
+		t.Errorf("fetches = %d, want 2", fetches)
+	}
+
+	// unknown key ids refetch at most once per minJWKSRefetchInterval
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.verify(ctx, rs256Token(t, other, "unknown", validClaims())); err == nil {
+		t.Error("verify with an unpublished key succeeded")
+	}
+	if fetches := atomic.LoadInt32(&server.fetches); fetches != 2 {
+		t.Errorf("fetches = %d, want 2", fetches)
+	}
+}
+
+func TestJWKSServesStaleKeysWhileRefetching(t *testing.T) {
+	server := newJWKSServer(t)
+	key := server.rotate(t, "key")
+	verifier := newJWTVerifier(&JWTOpts{JWKSURL: server.URL, HTTPClient: req.C(), JWKSRefreshInterval: time.Hour})
+	ctx := context.Background()
+
+	if _, err := verifier.verify(ctx, rs256Token(t, key, "key", validClaims())); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	// the issuer is down once the keys are stale, the cached key keeps verifying
+	server.Close()
+	verifier.jwks.mu.Lock()
+	verifier.jwks.fetchedAt = time.Now().Add(-2 * time.Hour)
+	verifier.jwks.mu.Unlock()
+
+	if _, err := verifier.verify(ctx, rs256Token(t, key, "key", validClaims())); err != nil {
+		t.Errorf("verify with the stale key: %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/owlify/sparrow/environment"
+	"github.com/owlify/sparrow/logger"
+	"github.com/owlify/sparrow/sentry"
+	"github.com/owlify/sparrow/web"
+)
+
+func TestMain(m *testing.M) {
+	logger.Init(logger.ERROR, environment.TestingEnv)
+	// the errors logged by the middlewares are reported to sentry, which is disabled in tests
+	if err := sentry.Init(environment.TestingEnv, ""); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// serve runs the request through the middleware in front of a handler answering 200
+func serve(middleware web.Middleware, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	middleware(func(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+		w.WriteHeader(http.StatusOK)
+	})(w, r, nil)
+	return w
+}
@@ -0,0 +1,140 @@
+package middlewares
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/owlify/sparrow/logger"
+	"github.com/owlify/sparrow/ratelimit"
+	"github.com/owlify/sparrow/web"
+)
+
+type RateLimitOpts struct {
+	Limiter ratelimit.Limiter
+	Limit   ratelimit.Limit
+	// Name scopes the counters, routes sharing a name share the limit.
+	// Defaults to the method and route so every endpoint is limited separately.
+	Name string
+	// KeyFunc identifies the client, defaults to the IP of the connection or, when it is one of the
+	// TrustedProxies, to the client IP they forwarded
+	KeyFunc func(*web.Request) string
+	// TrustedProxies are the IPs or CIDRs of the load balancers whose X-Forwarded-For is trusted,
+	// the header is ignored otherwise as any client can set it
+	TrustedProxies []string
+	// Version defaults to web.V1Api
+	Version web.ApiVersion
+}
+
+// RateLimit rejects requests over the limit with 429, requests are let through when the limiter fails.
+// It fails when a trusted proxy is neither an IP nor a CIDR.
+func RateLimit(rateLimitOpts *RateLimitOpts) (web.Middleware, error) {
+	opts := *rateLimitOpts
+	if opts.KeyFunc == nil {
+		trustedProxies, err := parseTrustedProxies(opts.TrustedProxies)
+		if err != nil {
+			return nil, err
+		}
+		opts.KeyFunc = clientIP(trustedProxies)
+	}
+	if opts.Version == "" {
+		opts.Version = web.V1Api
+	}
+
+	return func(next httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+			webReq := web.NewRequest(r)
+			for i := range params {
+				webReq.SetPathParam(params[i].Key, params[i].Value)
+			}
+
+			name := opts.Name
+			if name == "" {
+				name = fmt.Sprintf("%s:%s", r.Method, webReq.GetRoute())
+			}
+
+			result, err := opts.Limiter.Allow(r.Context(), fmt.Sprintf("%s:%s", name, opts.KeyFunc(webReq)), opts.Limit)
+			if err != nil {
+				logger.E(r.Context(), err, "[RateLimit] failed to check rate limit", logger.Field("name", name))
+				next(w, r, params)
+				return
+			}
+
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter.Seconds())))
+
+			if !result.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter.Seconds())))
+				web.WriteJsonResponse(w, web.ErrTooManyRequests("rate limit exceeded", opts.Version))
+				return
+			}
+
+			next(w, r, params)
+		}
+	}, nil
+}
+
+func ceilSeconds(seconds float64) int {
+	return int(math.Ceil(seconds))
+}
+
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("rate limit: invalid trusted proxy %q: %w", proxy, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// clientIP is the IP of the connection, unless it is a trusted proxy. The X-Forwarded-For IPs are then
+// read from the right, the first one which is not a trusted proxy is the client.
+func clientIP(trustedProxies []*net.IPNet) func(*web.Request) string {
+	trusted := func(ip string) bool {
+		parsed := net.ParseIP(ip)
+		for _, network := range trustedProxies {
+			if parsed != nil && network.Contains(parsed) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(r *web.Request) string {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		if !trusted(ip) {
+			return ip
+		}
+
+		var forwarded []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			forwarded = append(forwarded, strings.Split(header, ",")...)
+		}
+		for i := len(forwarded) - 1; i >= 0; i-- {
+			ip = strings.TrimSpace(forwarded[i])
+			if !trusted(ip) {
+				return ip
+			}
+		}
+		return ip
+	}
+}
@@ -0,0 +1,181 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/hmac"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/owlify/sparrow/cache"
+	"github.com/owlify/sparrow/errors"
+	sparrowhttp "github.com/owlify/sparrow/http"
+	"github.com/owlify/sparrow/logger"
+	"github.com/owlify/sparrow/web"
+)
+
+const (
+	defaultNonceTTL = 10 * time.Minute
+	defaultMaxSkew  = 5 * time.Minute
+)
+
+var errMissingServiceKeys = errors.NewWithCodef("service_auth_invalid_opts", "service auth: Keys is required")
+
+// ServiceKeyStore returns the keys the service may sign with, several while a key is being rotated
+type ServiceKeyStore interface {
+	Keys(ctx context.Context, serviceID string) ([]string, error)
+}
+
+// ServiceKeyStoreFunc adapts a function to a ServiceKeyStore, e.g. to read the keys from a secret manager
+type ServiceKeyStoreFunc func(ctx context.Context, serviceID string) ([]string, error)
+
+func (f ServiceKeyStoreFunc) Keys(ctx context.Context, serviceID string) ([]string, error) {
+	return f(ctx, serviceID)
+}
+
+// StaticServiceKeys maps the service ids to their keys, list the new key next to the old one to rotate
+type StaticServiceKeys map[string][]string
+
+func (k StaticServiceKeys) Keys(_ context.Context, serviceID string) ([]string, error) {
+	return k[serviceID], nil
+}
+
+type ServiceAuthOpts struct {
+	// Keys is required
+	Keys ServiceKeyStore
+	// Cache remembers the nonces to reject replayed requests, replays are not detected when nil and a
+	// warning is logged when the middleware is created
+	Cache cache.CacheV2
+	// NonceTTL is how long nonces are remembered, defaults to 10m and is at least twice MaxSkew
+	NonceTTL time.Duration
+	// MaxSkew rejects signatures timestamped further from now, defaults to 5m
+	MaxSkew time.Duration
+	// AllowNoncesWithoutTimestamp accepts the nonces of older clients which are not prefixed with the
+	// signing time, set it only while those clients are upgraded as their signatures never expire
+	AllowNoncesWithoutTimestamp bool
+	// Version defaults to web.V1Api
+	Version web.ApiVersion
+}
+
+type serviceIDKey struct{}
+
+// ServiceID returns the service authenticated by ServiceAuth
+func ServiceID(ctx context.Context) string {
+	serviceID, _ := ctx.Value(serviceIDKey{}).(string)
+	return serviceID
+}
+
+// ServiceAuth verifies the signature headers set by http.InternalAuthHTTPClient and puts the
+// service id in the request context, see ServiceID.
+//
+// The signature only covers the service id and the nonce, not the method, path or body. It proves which
+// service sent the request, a party able to intercept it could replay the headers on another request
+// before the nonce is recorded, so the services must talk over TLS.
+//
+// Nonces are signed as "<unix>.<hex>" and the unix prefix is checked against MaxSkew. Nonces without
+// it, sent by clients older than this release, are rejected unless AllowNoncesWithoutTimestamp is set,
+// so set it while the callers of a service are upgraded.
+func ServiceAuth(serviceAuthOpts *ServiceAuthOpts) (web.Middleware, error) {
+	// copied so that the defaults are not applied to the caller's opts
+	opts := *serviceAuthOpts
+	if opts.Keys == nil {
+		return nil, errMissingServiceKeys
+	}
+	if opts.Cache == nil {
+		logger.W(context.Background(), "[ServiceAuth] no nonce cache configured, replayed requests are not rejected")
+	}
+	if opts.MaxSkew <= 0 {
+		opts.MaxSkew = defaultMaxSkew
+	}
+	if opts.NonceTTL <= 0 {
+		opts.NonceTTL = defaultNonceTTL
+	}
+	if opts.NonceTTL < 2*opts.MaxSkew {
+		opts.NonceTTL = 2 * opts.MaxSkew
+	}
+	if opts.Version == "" {
+		opts.Version = web.V1Api
+	}
+
+	return func(next httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+			ctx := r.Context()
+			serviceID := r.Header.Get(sparrowhttp.ServiceIDHeader)
+			nonce := r.Header.Get(sparrowhttp.ServiceNonceHeader)
+			signature := r.Header.Get(sparrowhttp.ServiceSignatureHeader)
+			if serviceID == "" || nonce == "" || signature == "" {
+				web.WriteJsonResponse(w, web.ErrUnauthenticatedRequest("missing service signature", opts.Version))
+				return
+			}
+
+			keys, err := opts.Keys.Keys(ctx, serviceID)
+			if err != nil {
+				logger.E(ctx, err, "[ServiceAuth] failed to get service keys", logger.Field("service_id", serviceID))
+				web.WriteJsonResponse(w, web.ErrInternalServerError("failed to verify service signature", opts.Version))
+				return
+			}
+			if !signedWithAny(serviceID, nonce, signature, keys) {
+				logger.W(ctx, "[ServiceAuth] invalid service signature", logger.Field("service_id", serviceID))
+				web.WriteJsonResponse(w, web.ErrUnauthenticatedRequest("invalid service signature", opts.Version))
+				return
+			}
+
+			if reason := checkNonceTime(nonce, &opts); reason != "" {
+				logger.W(ctx, "[ServiceAuth] "+reason, logger.Field("service_id", serviceID))
+				web.WriteJsonResponse(w, web.ErrUnauthenticatedRequest(reason, opts.Version))
+				return
+			}
+
+			if opts.Cache != nil {
+				fresh, err := opts.Cache.SetNX(ctx, cache.GetKey("service_nonce", serviceID, nonce), 1, opts.NonceTTL)
+				if err != nil {
+					logger.E(ctx, err, "[ServiceAuth] failed to record nonce", logger.Field("service_id", serviceID))
+					web.WriteJsonResponse(w, web.ErrInternalServerError("failed to verify service signature", opts.Version))
+					return
+				}
+				if !fresh {
+					logger.W(ctx, "[ServiceAuth] replayed nonce", logger.Field("service_id", serviceID))
+					web.WriteJsonResponse(w, web.ErrUnauthenticatedRequest("service signature already used", opts.Version))
+					return
+				}
+			}
+
+			next(w, r.WithContext(context.WithValue(ctx, serviceIDKey{}, serviceID)), params)
+		}
+	}, nil
+}
+
+func signedWithAny(serviceID, nonce, signature string, keys []string) bool {
+	signature = strings.ToLower(signature)
+	for _, key := range keys {
+		expected := sparrowhttp.ServiceSignature(serviceID, key, nonce)
+		if hmac.Equal([]byte(expected), []byte(signature)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNonceTime checks the signing time prefixing the nonce, returns why the nonce is rejected
+func checkNonceTime(nonce string, opts *ServiceAuthOpts) string {
+	prefix, _, timed := strings.Cut(nonce, ".")
+	signedAt, err := strconv.ParseInt(prefix, 10, 64)
+	if !timed || err != nil {
+		if !opts.AllowNoncesWithoutTimestamp {
+			return "service signature without timestamp"
+		}
+		return ""
+	}
+
+	skew := time.Since(time.Unix(signedAt, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > opts.MaxSkew {
+		return "service signature expired"
+	}
+	return ""
+}
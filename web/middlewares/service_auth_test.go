@@ -0,0 +1,170 @@
+package middlewares
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/owlify/sparrow/cache"
+	sparrowhttp "github.com/owlify/sparrow/http"
+	"github.com/owlify/sparrow/web"
+)
+
+func signedRequest(serviceID, key, nonce string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/internal", nil)
+	r.Header.Set(sparrowhttp.ServiceIDHeader, serviceID)
+	r.Header.Set(sparrowhttp.ServiceNonceHeader, nonce)
+	r.Header.Set(sparrowhttp.ServiceSignatureHeader, sparrowhttp.ServiceSignature(serviceID, key, nonce))
+	return r
+}
+
+func nonceAt(signedAt time.Time) string {
+	return fmt.Sprintf("%d.%d", signedAt.Unix(), signedAt.UnixNano())
+}
+
+func nonceCache() cache.CacheV2 {
+	cache.InitRistrettoCache(1<<20, 1e4)
+	return cache.NewRistrettoCacheV2()
+}
+
+func serviceAuth(t *testing.T, opts *ServiceAuthOpts) web.Middleware {
+	t.Helper()
+	auth, err := ServiceAuth(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auth
+}
+
+func TestServiceAuthRequiresKeys(t *testing.T) {
+	if _, err := ServiceAuth(&ServiceAuthOpts{Cache: nonceCache()}); err == nil {
+		t.Error("ServiceAuth accepted opts without Keys")
+	}
+}
+
+func TestServiceAuthAcceptsValidSignature(t *testing.T) {
+	auth := serviceAuth(t, &ServiceAuthOpts{Keys: StaticServiceKeys{"orders": {"secret"}}, Cache: nonceCache()})
+
+	var serviceID string
+	w := httptest.NewRecorder()
+	auth(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		serviceID = ServiceID(r.Context())
+	})(w, signedRequest("orders", "secret", nonceAt(time.Now())), nil)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if serviceID != "orders" {
+		t.Errorf("ServiceID = %q, want orders", serviceID)
+	}
+}
+
+func TestServiceAuthRejectsSignatureMismatch(t *testing.T) {
+	auth := serviceAuth(t, &ServiceAuthOpts{Keys: StaticServiceKeys{"orders": {"secret"}}, Cache: nonceCache()})
+
+	tests := map[string]*http.Request{
+		"wrong key":     signedRequest("orders", "other", nonceAt(time.Now())),
+		"unknown id":    signedRequest("payments", "secret", nonceAt(time.Now())),
+		"missing nonce": signedRequest("orders", "secret", ""),
+	}
+	tampered := signedRequest("orders", "secret", nonceAt(time.Now()))
+	tampered.Header.Set(sparrowhttp.ServiceNonceHeader, nonceAt(time.Now().Add(time.Second)))
+	tests["tampered nonce"] = tampered
+
+	for name, r := range tests {
+		t.Run(name, func(t *testing.T) {
+			if w := serve(auth, r); w.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+func TestServiceAuthRejectsReplayedNonce(t *testing.T) {
+	auth := serviceAuth(t, &ServiceAuthOpts{Keys: StaticServiceKeys{"orders": {"secret"}}, Cache: nonceCache()})
+	nonce := nonceAt(time.Now())
+
+	if w := serve(auth, signedRequest("orders", "secret", nonce)); w.Code != http.StatusOK {
+		t.Fatalf("first request status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := serve(auth, signedRequest("orders", "secret", nonce)); w.Code != http.StatusUnauthorized {
+		t.Errorf("replayed request status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestServiceAuthRejectsClockSkew(t *testing.T) {
+	auth := serviceAuth(t, &ServiceAuthOpts{
+		Keys:    StaticServiceKeys{"orders": {"secret"}},
+		Cache:   nonceCache(),
+		MaxSkew: time.Minute,
+	})
+
+	tests := map[string]struct {
+		nonce string
+		want  int
+	}{
+		"within skew":    {nonce: nonceAt(time.Now().Add(-30 * time.Second)), want: http.StatusOK},
+		"expired":        {nonce: nonceAt(time.Now().Add(-2 * time.Minute)), want: http.StatusUnauthorized},
+		"future":         {nonce: nonceAt(time.Now().Add(2 * time.Minute)), want: http.StatusUnauthorized},
+		"without a time": {nonce: "0123456789abcdef", want: http.StatusUnauthorized},
+		"malformed time": {nonce: "soon.0123456789abcdef", want: http.StatusUnauthorized},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if w := serve(auth, signedRequest("orders", "secret", test.nonce)); w.Code != test.want {
+				t.Errorf("status = %d, want %d", w.Code, test.want)
+			}
+		})
+	}
+}
+
+func TestServiceAuthAllowsNoncesWithoutTimestamp(t *testing.T) {
+	auth := serviceAuth(t, &ServiceAuthOpts{
+		Keys:                        StaticServiceKeys{"orders": {"secret"}},
+		Cache:                       nonceCache(),
+		AllowNoncesWithoutTimestamp: true,
+	})
+
+	if w := serve(auth, signedRequest("orders", "secret", "0123456789abcdef")); w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestServiceAuthKeyRotation(t *testing.T) {
+	keys := map[string][]string{"orders": {"old", "new"}}
+	auth := serviceAuth(t, &ServiceAuthOpts{
+		Keys: ServiceKeyStoreFunc(func(_ context.Context, serviceID string) ([]string, error) {
+			return keys[serviceID], nil
+		}),
+		Cache: nonceCache(),
+	})
+
+	for _, key := range []string{"old", "new"} {
+		if w := serve(auth, signedRequest("orders", key, nonceAt(time.Now()))); w.Code != http.StatusOK {
+			t.Errorf("signed with %s: status = %d, want %d", key, w.Code, http.StatusOK)
+		}
+	}
+
+	// once rotated the old key is refused
+	keys["orders"] = []string{"new"}
+	if w := serve(auth, signedRequest("orders", "old", nonceAt(time.Now()))); w.Code != http.StatusUnauthorized {
+		t.Errorf("signed with the retired key: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := serve(auth, signedRequest("orders", "new", nonceAt(time.Now()))); w.Code != http.StatusOK {
+		t.Errorf("signed with the new key: status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestServiceAuthDoesNotMutateOpts(t *testing.T) {
+	opts := &ServiceAuthOpts{Keys: StaticServiceKeys{}, Cache: nonceCache()}
+	serviceAuth(t, opts)
+
+	if opts.MaxSkew != 0 || opts.NonceTTL != 0 || opts.Version != "" {
+		t.Errorf("opts were given defaults: %+v", opts)
+	}
+}
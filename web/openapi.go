@@ -0,0 +1,351 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	openAPIVersion          = "3.0.3"
+	defaultOpenAPIPath      = "/openapi.json"
+	internalErrorStatusCode = "500"
+
+	// SwaggerUICDN serves the swagger-ui-dist files from unpkg, for services allowed to load scripts
+	// from a public CDN
+	SwaggerUICDN = "https://unpkg.com/swagger-ui-dist@5"
+)
+
+type OpenAPIOpts struct {
+	Title       string
+	Version     string
+	Description string
+	// Servers are the base URLs of the API, e.g. https://api.example.com
+	Servers []string
+
+	// SpecPath serves the specification, defaults to /openapi.json
+	SpecPath string
+	// SwaggerUIPath serves the Swagger UI when set, e.g. /docs
+	SwaggerUIPath string
+	// SwaggerUIAssets is the base URL of the swagger-ui-dist files, required with SwaggerUIPath.
+	// Set it to SwaggerUICDN to load them from unpkg.
+	SwaggerUIAssets string
+}
+
+type openAPIDocument struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       openAPIInfo                     `json:"info"`
+	Servers    []openAPIServer                 `json:"servers,omitempty"`
+	Paths      map[string]map[string]operation `json:"paths"`
+	Components openAPIComponents               `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type openAPIServer struct {
+	URL string `json:"url"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*schema `json:"schemas"`
+}
+
+type operation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary,omitempty"`
+	Description string                     `json:"description,omitempty"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Deprecated  bool                       `json:"deprecated,omitempty"`
+	Parameters  []parameter                `json:"parameters,omitempty"`
+	RequestBody *requestBody               `json:"requestBody,omitempty"`
+	Responses   map[string]operationResult `json:"responses"`
+}
+
+type parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type operationResult struct {
+	Description string               `json:"description"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+type mediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+// OpenAPISpec generates the OpenAPI 3 document of the routes, e.g. to publish it from CI
+func OpenAPISpec(opts *OpenAPIOpts, routes []Route) ([]byte, error) {
+	generator := newSchemaGenerator()
+	doc := openAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info:    openAPIInfo{Title: opts.Title, Version: opts.Version, Description: opts.Description},
+		Paths:   map[string]map[string]operation{},
+	}
+	for _, server := range opts.Servers {
+		doc.Servers = append(doc.Servers, openAPIServer{URL: server})
+	}
+
+	for _, route := range routes {
+		path, pathParams := openAPIPath(route.Path)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]operation{}
+		}
+		doc.Paths[path][strings.ToLower(route.Method)] = generator.operation(route, pathParams)
+	}
+
+	generator.structRef(reflect.TypeOf(Error{}))
+	doc.Components = openAPIComponents{Schemas: generator.components}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// ServeOpenAPI serves the specification of the routes registered with HandleRoute, and the Swagger UI.
+// It fails when SwaggerUIPath is set without SwaggerUIAssets.
+func (s *Server) ServeOpenAPI(openAPIOpts *OpenAPIOpts) error {
+	opts := &OpenAPIOpts{}
+	*opts = *openAPIOpts
+	if opts.SpecPath == "" {
+		opts.SpecPath = defaultOpenAPIPath
+	}
+	if opts.SwaggerUIPath != "" && opts.SwaggerUIAssets == "" {
+		return fmt.Errorf("openapi: SwaggerUIAssets is required to serve the Swagger UI at %s", opts.SwaggerUIPath)
+	}
+
+	s.router.GET(opts.SpecPath, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		spec, err := OpenAPISpec(opts, s.Routes())
+		if err != nil {
+			WriteJsonResponse(w, ErrorResponse(r.Context(), err, V1Api))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(spec)
+	})
+
+	if opts.SwaggerUIPath != "" {
+		s.router.GET(opts.SwaggerUIPath, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_ = swaggerUITemplate.Execute(w, opts)
+		})
+	}
+	return nil
+}
+
+var swaggerUITemplate = template.Must(template.New("swagger-ui").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8"/>
+  <title>{{.Title}}</title>
+  <link rel="stylesheet" href="{{.SwaggerUIAssets}}/swagger-ui.css"/>
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="{{.SwaggerUIAssets}}/swagger-ui-bundle.js"></script>
+  <script>
+    window.onload = function () {
+      SwaggerUIBundle({url: "{{.SpecPath}}", dom_id: "#swagger-ui"});
+    };
+  </script>
+</body>
+</html>
+`))
+
+// openAPIPath converts the router params, :id and *path, to {id} and {path}
+func openAPIPath(path string) (string, []string) {
+	segments := strings.Split(path, "/")
+	var params []string
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			params = append(params, segment[1:])
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+func (g *schemaGenerator) operation(route Route, pathParams []string) operation {
+	op := operation{
+		OperationID: route.OperationID,
+		Summary:     route.Summary,
+		Description: route.Description,
+		Tags:        route.Tags,
+		Deprecated:  route.Deprecated,
+		Responses:   map[string]operationResult{},
+	}
+	if op.OperationID == "" {
+		op.OperationID = operationID(route.Method, route.Path)
+	}
+
+	errorCodes := route.Errors
+	if route.Request != nil {
+		requestType := reflect.TypeOf(route.Request)
+		for requestType.Kind() == reflect.Pointer {
+			requestType = requestType.Elem()
+		}
+		op.Parameters = g.parameters(requestType)
+		op.RequestBody = g.requestBody(requestType)
+		// the typed endpoints respond bad_request when the input cannot be bound or is invalid
+		errorCodes = append([]string{BadRequest}, errorCodes...)
+	}
+
+	// path params missing from the request type are still documented as strings
+	for _, name := range pathParams {
+		documented := false
+		for _, param := range op.Parameters {
+			documented = documented || (param.In == "path" && param.Name == name)
+		}
+		if !documented {
+			op.Parameters = append(op.Parameters, parameter{Name: name, In: "path", Required: true, Schema: &schema{Type: "string"}})
+		}
+	}
+
+	if route.Status == 0 {
+		route.Status = http.StatusOK
+	}
+	op.Responses[strconv.Itoa(route.Status)] = g.successResult(route)
+	for status, codes := range errorStatuses(errorCodes) {
+		op.Responses[status] = operationResult{
+			Description: strings.Join(codes, ", "),
+			Content:     map[string]mediaType{"application/json": {Schema: g.structRef(reflect.TypeOf(Error{}))}},
+		}
+	}
+	return op
+}
+
+// parameters lists the fields tagged path, query or header, including those of embedded structs
+func (g *schemaGenerator) parameters(t reflect.Type) []parameter {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var params []parameter
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			params = append(params, g.parameters(field.Type)...)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		for _, in := range []string{"path", "query", "header"} {
+			name := field.Tag.Get(in)
+			if name == "" {
+				continue
+			}
+
+			paramSchema := g.schemaOf(field.Type)
+			if field.Type == durationType {
+				// parameters are parsed with time.ParseDuration, e.g. 1m30s
+				paramSchema = &schema{Type: "string"}
+			}
+			required := applyValidateTag(paramSchema, field.Tag.Get("validate"))
+			params = append(params, parameter{Name: name, In: in, Required: required || in == "path", Schema: paramSchema})
+			break
+		}
+	}
+	return params
+}
+
+// requestBody documents the json fields of the request type, nil when it only has parameters
+func (g *schemaGenerator) requestBody(t reflect.Type) *requestBody {
+	var body *schema
+	if t.Kind() == reflect.Struct {
+		body = &schema{Type: "object", Properties: map[string]*schema{}}
+		g.addFields(body, t, true)
+		if len(body.Properties) == 0 {
+			return nil
+		}
+	} else {
+		body = g.schemaOf(t)
+	}
+	return &requestBody{Required: true, Content: map[string]mediaType{"application/json": {Schema: body}}}
+}
+
+// successResult wraps the response type in the success envelope
+func (g *schemaGenerator) successResult(route Route) operationResult {
+	envelope := &schema{
+		Type: "object",
+		Properties: map[string]*schema{
+			"success": {Type: "boolean"},
+			"version": {Type: "string"},
+		},
+		Required: []string{"success", "version"},
+	}
+	if route.Response != nil {
+		envelope.Properties["data"] = g.schemaOf(reflect.TypeOf(route.Response))
+	}
+	return operationResult{
+		Description: http.StatusText(route.Status),
+		Content:     map[string]mediaType{"application/json": {Schema: envelope}},
+	}
+}
+
+// errorStatuses groups the error codes by the status they are registered with, unknown codes are a 500
+func errorStatuses(codes []string) map[string][]string {
+	errorRegistryMu.RLock()
+	defer errorRegistryMu.RUnlock()
+
+	statuses := map[string][]string{}
+	for _, code := range codes {
+		status := internalErrorStatusCode
+		if mapping, found := errorRegistry[code]; found {
+			status = strconv.Itoa(mapping.Status)
+		}
+		if !contains(statuses[status], code) {
+			statuses[status] = append(statuses[status], code)
+		}
+	}
+	for _, grouped := range statuses {
+		sort.Strings(grouped)
+	}
+	return statuses
+}
+
+// operationID derives an id such as getUsersById from the method and path
+func operationID(method string, path string) string {
+	id := strings.ToLower(method)
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" {
+			continue
+		}
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segment = "by_" + segment[1:]
+		}
+		for _, word := range strings.FieldsFunc(segment, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+		}) {
+			id += strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return id
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,337 @@
+package web
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// schema is an OpenAPI 3.0 schema object
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	AdditionalProperties *schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	ExclusiveMinimum     bool               `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     bool               `json:"exclusiveMaximum,omitempty"`
+	MinLength            *float64           `json:"minLength,omitempty"`
+	MaxLength            *float64           `json:"maxLength,omitempty"`
+	MinItems             *float64           `json:"minItems,omitempty"`
+	MaxItems             *float64           `json:"maxItems,omitempty"`
+	UniqueItems          bool               `json:"uniqueItems,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+}
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	durationType        = reflect.TypeOf(time.Duration(0))
+	uuidType            = reflect.TypeOf(uuid.UUID{})
+	rawMessageType      = reflect.TypeOf(json.RawMessage{})
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	invalidSchemaName   = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+	validateFormatsTags = map[string]string{
+		"email":    "email",
+		"url":      "uri",
+		"uri":      "uri",
+		"uuid":     "uuid",
+		"uuid4":    "uuid",
+		"ipv4":     "ipv4",
+		"ipv6":     "ipv6",
+		"hostname": "hostname",
+		"datetime": "date-time",
+		"base64":   "byte",
+	}
+)
+
+// schemaGenerator builds the schemas of go types, structs are added to the components and referenced
+type schemaGenerator struct {
+	components map[string]*schema
+	names      map[reflect.Type]string
+}
+
+func newSchemaGenerator() *schemaGenerator {
+	return &schemaGenerator{
+		components: map[string]*schema{},
+		names: map[reflect.Type]string{
+			reflect.TypeOf(Error{}):       "Error",
+			reflect.TypeOf(errorDetail{}): "ErrorDetail",
+		},
+	}
+}
+
+func (g *schemaGenerator) schemaOf(t reflect.Type) *schema {
+	nullable := false
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+		nullable = true
+	}
+
+	s := g.typeSchema(t)
+	if nullable && s.Ref == "" {
+		s.Nullable = true
+	}
+	return s
+}
+
+func (g *schemaGenerator) typeSchema(t reflect.Type) *schema {
+	switch t {
+	case timeType:
+		return &schema{Type: "string", Format: "date-time"}
+	case uuidType:
+		return &schema{Type: "string", Format: "uuid"}
+	case rawMessageType:
+		return &schema{}
+	}
+	if t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType) {
+		return &schema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.Int, reflect.Int64:
+		return &schema{Type: "integer", Format: "int64"}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return &schema{Type: "integer", Format: "int32"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &schema{Type: "integer", Minimum: float(0)}
+	case reflect.Float32:
+		return &schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &schema{Type: "string", Format: "byte"}
+		}
+		return &schema{Type: "array", Items: g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &schema{Type: "object", AdditionalProperties: g.schemaOf(t.Elem())}
+	case reflect.Struct:
+		return g.structRef(t)
+	default:
+		return &schema{}
+	}
+}
+
+// structRef adds the struct to the components once and references it, anonymous structs are inlined
+func (g *schemaGenerator) structRef(t reflect.Type) *schema {
+	if t.Name() == "" {
+		return g.structSchema(t)
+	}
+
+	name, found := g.names[t]
+	if !found {
+		name = g.uniqueName(t)
+		g.names[t] = name
+	}
+	if _, generated := g.components[name]; !generated {
+		// reserved before generating the fields so that recursive types terminate
+		g.components[name] = &schema{}
+		*g.components[name] = *g.structSchema(t)
+	}
+	return &schema{Ref: "#/components/schemas/" + name}
+}
+
+func (g *schemaGenerator) uniqueName(t reflect.Type) string {
+	name := invalidSchemaName.ReplaceAllString(t.Name(), "_")
+	taken := func(name string) bool {
+		for other, otherName := range g.names {
+			if otherName == name && other != t {
+				return true
+			}
+		}
+		return false
+	}
+	if !taken(name) {
+		return name
+	}
+
+	pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+	qualified := invalidSchemaName.ReplaceAllString(pkg, "_") + "." + name
+	name = qualified
+	for i := 2; taken(name); i++ {
+		name = qualified + strconv.Itoa(i)
+	}
+	return name
+}
+
+// structSchema describes the json fields of the struct, flattening embedded structs as encoding/json does
+func (g *schemaGenerator) structSchema(t reflect.Type) *schema {
+	s := &schema{Type: "object", Properties: map[string]*schema{}}
+	g.addFields(s, t, false)
+	return s
+}
+
+// addFields adds the json fields of t, bodyOnly skips the fields bound from the path, query or headers
+func (g *schemaGenerator) addFields(s *schema, t reflect.Type, bodyOnly bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, skip := jsonField(field)
+		if skip || (bodyOnly && isParameterField(field)) {
+			continue
+		}
+
+		if field.Anonymous && field.Tag.Get("json") == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				g.addFields(s, embedded, bodyOnly)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		fieldSchema := g.schemaOf(field.Type)
+		required := applyValidateTag(fieldSchema, field.Tag.Get("validate"))
+		s.Properties[name] = fieldSchema
+		if required {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// jsonField returns the name of the field as encoded by encoding/json
+func jsonField(field reflect.StructField) (name string, skip bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", true
+	}
+	name = strings.SplitN(tag, ",", 2)[0]
+	if name == "" {
+		name = field.Name
+	}
+	return name, false
+}
+
+func isParameterField(field reflect.StructField) bool {
+	return field.Tag.Get("json") == "" &&
+		(field.Tag.Get("path") != "" || field.Tag.Get("query") != "" || field.Tag.Get("header") != "")
+}
+
+// applyValidateTag adds the constraints of the validate tag to the schema and reports whether the
+// field is required. The tags after dive apply to the items.
+func applyValidateTag(s *schema, tag string) bool {
+	if tag == "" || tag == "-" {
+		return false
+	}
+
+	required := false
+	target := s
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		if s.Ref != "" && target == s && name != "required" && name != "dive" {
+			// constraints cannot be added next to a reference in OpenAPI 3.0
+			continue
+		}
+
+		switch name {
+		case "required":
+			if target == s {
+				required = true
+			}
+		case "dive":
+			if target.Items == nil {
+				return required
+			}
+			target = target.Items
+		case "omitempty":
+		case "oneof":
+			for _, value := range strings.Fields(param) {
+				target.Enum = append(target.Enum, enumValue(target.Type, value))
+			}
+		case "len":
+			applyBound(target, "min", param)
+			applyBound(target, "max", param)
+		case "min", "gte":
+			applyBound(target, "min", param)
+		case "max", "lte":
+			applyBound(target, "max", param)
+		case "gt":
+			if applyBound(target, "min", param) && target.Minimum != nil {
+				target.ExclusiveMinimum = true
+			}
+		case "lt":
+			if applyBound(target, "max", param) && target.Maximum != nil {
+				target.ExclusiveMaximum = true
+			}
+		case "unique":
+			target.UniqueItems = target.Type == "array"
+		case "e164":
+			target.Pattern = `^\+[1-9]\d{1,14}$`
+		default:
+			if format, ok := validateFormatsTags[name]; ok && target.Type == "string" {
+				target.Format = format
+			}
+		}
+	}
+	return required
+}
+
+// applyBound sets the length, items or value bound depending on the schema type
+func applyBound(s *schema, bound string, param string) bool {
+	value, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return false
+	}
+
+	switch s.Type {
+	case "string":
+		if bound == "min" {
+			s.MinLength = float(value)
+		} else {
+			s.MaxLength = float(value)
+		}
+	case "array":
+		if bound == "min" {
+			s.MinItems = float(value)
+		} else {
+			s.MaxItems = float(value)
+		}
+	case "integer", "number":
+		if bound == "min" {
+			s.Minimum = float(value)
+		} else {
+			s.Maximum = float(value)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+func enumValue(schemaType string, value string) interface{} {
+	switch schemaType {
+	case "integer":
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i
+		}
+	case "number":
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return value
+}
+
+func float(value float64) *float64 {
+	return &value
+}
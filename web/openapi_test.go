@@ -0,0 +1,87 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files")
+
+type auditFields struct {
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedBy *string   `json:"updated_by,omitempty"`
+}
+
+type owlCategory struct {
+	Name     string        `json:"name" validate:"required,max=32"`
+	Children []owlCategory `json:"children,omitempty"`
+	Parent   *owlCategory  `json:"parent,omitempty"`
+}
+
+type createOwlRequest struct {
+	auditFields
+	ColonyID  string       `path:"colony_id" validate:"required,uuid4"`
+	DryRun    bool         `query:"dry_run"`
+	RequestID string       `header:"X-Request-Id"`
+	Name      string       `json:"name" validate:"required,min=2,max=64"`
+	Species   string       `json:"species" validate:"oneof=barn snowy tawny"`
+	Wingspan  float64      `json:"wingspan" validate:"gt=0,lte=250"`
+	Email     string       `json:"email,omitempty" validate:"omitempty,email"`
+	Tags      []string     `json:"tags" validate:"max=5,unique"`
+	Category  *owlCategory `json:"category"`
+}
+
+type owlResponse struct {
+	auditFields
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestOpenAPISpecGolden(t *testing.T) {
+	s := NewServer(&ServerOpts{})
+	HandleTyped(s, Route{
+		Method:  http.MethodPost,
+		Path:    "/colonies/:colony_id/owls",
+		Summary: "Add an owl to a colony",
+		Tags:    []string{"owls"},
+		Status:  http.StatusCreated,
+		Errors:  []string{NotFound},
+	}, func(ctx context.Context, r *Request, in createOwlRequest) (owlResponse, error) {
+		return owlResponse{}, nil
+	})
+
+	spec, err := OpenAPISpec(&OpenAPIOpts{Title: "owls", Version: "1.0.0"}, s.Routes())
+	if err != nil {
+		t.Fatalf("OpenAPISpec: %v", err)
+	}
+
+	golden := filepath.Join("testdata", "openapi.golden.json")
+	if *updateGolden {
+		if err := os.WriteFile(golden, spec, 0o644); err != nil {
+			t.Fatalf("write golden: %v", err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("read golden: %v", err)
+	}
+	if !bytes.Equal(bytes.TrimSpace(spec), bytes.TrimSpace(want)) {
+		t.Errorf("spec differs from %s, run go test ./web -run TestOpenAPISpecGolden -update and review the diff\n%s", golden, spec)
+	}
+}
+
+func TestServeOpenAPIRequiresSwaggerUIAssets(t *testing.T) {
+	s := NewServer(&ServerOpts{})
+	if err := s.ServeOpenAPI(&OpenAPIOpts{SwaggerUIPath: "/docs"}); err == nil {
+		t.Error("ServeOpenAPI without SwaggerUIAssets succeeded")
+	}
+	if err := s.ServeOpenAPI(&OpenAPIOpts{SwaggerUIPath: "/docs", SwaggerUIAssets: SwaggerUICDN}); err != nil {
+		t.Errorf("ServeOpenAPI: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+// Claims are the claims of a verified token, numbers are json.Number
+type Claims map[string]interface{}
+
+// Principal is the authenticated caller, set by the authentication middlewares
+type Principal struct {
+	Subject string
+	Scopes  []string
+	Roles   []string
+	Claims  Claims
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying the authenticated caller
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the authenticated caller, nil when the request is not authenticated
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// Principal returns the authenticated caller, nil when the request is not authenticated
+func (r *Request) Principal() *Principal {
+	return PrincipalFromContext(r.Context())
+}
+
+// Claims returns the claims of the verified token, nil when the request is not authenticated
+func (r *Request) Claims() Claims {
+	if principal := r.Principal(); principal != nil {
+		return principal.Claims
+	}
+	return nil
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	return contains(p.Scopes, scope)
+}
+
+func (p *Principal) HasRole(role string) bool {
+	return contains(p.Roles, role)
+}
+
+// String returns the claim as a string, empty when it is missing or not a string
+func (c Claims) String(name string) string {
+	value, _ := c.Lookup(name).(string)
+	return value
+}
+
+// Strings returns a claim holding a list, or a space separated string as the OAuth2 scope claim does
+func (c Claims) Strings(name string) []string {
+	switch value := c.Lookup(name).(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// Int64 returns a numeric claim such as exp, ok is false when it is missing or not a number
+func (c Claims) Int64(name string) (int64, bool) {
+	switch value := c.Lookup(name).(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i, true
+		}
+		if f, err := value.Float64(); err == nil {
+			return int64(f), true
+		}
+	case float64:
+		return int64(value), true
+	}
+	return 0, false
+}
+
+// Lookup returns the claim, nested claims are reached by a dotted path such as realm_access.roles
+func (c Claims) Lookup(name string) interface{} {
+	if value, ok := c[name]; ok {
+		return value
+	}
+
+	var current interface{} = map[string]interface{}(c)
+	for _, part := range strings.Split(name, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = object[part]
+	}
+	return current
+}
@@ -12,9 +12,8 @@ import (
 	"reflect"
 	"strings"
 
+	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
-	"github.com/go-playground/validator/v10/non-standard/validators"
-	"github.com/google/uuid"
 	"github.com/newrelic/go-agent/v3/newrelic"
 
 	"github.com/owlify/sparrow/errors"
@@ -42,6 +41,7 @@ type ValidationError struct {
 	errorType string
 	message   string
 	err       error
+	fields    []FieldError
 }
 
 func (e *ValidationError) Error() string { return e.message }
@@ -52,6 +52,9 @@ func (e *ValidationError) Unwrap() error { return e.err }
 
 func (e *ValidationError) IsUnexpectedErr() bool { return e.errorType == UnexpectedErr }
 
+// Fields lists the failed fields of InvalidValue errors
+func (e *ValidationError) Fields() []FieldError { return e.fields }
+
 var (
 	UnexpectedErr  = "Unexpected"
 	ErrInvalidType = func(field string, expectedType interface{}, err error) ValidationErrorInterface {
@@ -197,15 +200,41 @@ func (r *Request) ReadBody() (map[string]interface{}, error) {
 func (r *Request) ParseAndValidateBody(s interface{}) error {
 	var e error
 	if err := r.Bind(s); err != nil {
-		e = handleValidationErrors(err)
+		e = handleValidationErrors(err, r.validationTranslator())
 		return errors.NewWithErr("bad_request", e)
 
 	}
 
-	e = validateStruct(s)
+	e = validateStruct(s, r.validationTranslator())
+	return errors.NewWithErr("bad_request", e)
+}
+
+// ParseAndValidateHeaders binds the fields tagged header, e.g. `header:"X-Client-Id"`, and validates them
+func (r *Request) ParseAndValidateHeaders(s interface{}) error {
+	value := reflect.ValueOf(s)
+	if value.Kind() != reflect.Pointer || value.Elem().Kind() != reflect.Struct {
+		return errors.NewWithCodef("bad_request", "headers can only be bound to a struct pointer, got %T", s)
+	}
+	if err := r.bindFields(value.Elem()); err != nil {
+		return errors.NewWithErr("bad_request", err)
+	}
+
+	e := validateStruct(s, r.validationTranslator())
 	return errors.NewWithErr("bad_request", e)
 }
 
+// ReadAndValidateBody reads the body as ReadBody does and validates its values against the rules,
+// e.g. {"name": "required,max=64", "address": {"city": "required"}}
+func (r *Request) ReadAndValidateBody(rules map[string]interface{}) (map[string]interface{}, error) {
+	body, err := r.ReadBody()
+	if err != nil {
+		return body, errors.NewWithErr("bad_request", handleValidationErrors(err, r.validationTranslator()))
+	}
+
+	e := validateMap(body, rules, r.validationTranslator())
+	return body, errors.NewWithErr("bad_request", e)
+}
+
 func (r *Request) ParseAndValidateParams(s interface{}, structValidations ...validator.StructLevelFunc) error {
 
 	queryParams := r.QueryParams()
@@ -222,75 +251,66 @@ func (r *Request) ParseAndValidateParams(s interface{}, structValidations ...val
 	err := json.Unmarshal(jsonString, &s)
 	var e error
 	if err != nil {
-		e = handleValidationErrors(err)
+		e = handleValidationErrors(err, r.validationTranslator())
 		return errors.NewWithErr("bad_request", e)
 	}
-	e = validateStruct(s, structValidations...)
+	e = validateStruct(s, r.validationTranslator(), structValidations...)
 	return errors.NewWithErr("bad_request", e)
 }
 
-func validateStruct(s interface{}, structValidations ...validator.StructLevelFunc) ValidationErrorInterface {
-	var validate = validator.New()
-
-	_ = validate.RegisterValidation("notblank", validators.NotBlank)
+// validationTranslator localizes validation messages in the language of the request
+func (r *Request) validationTranslator() ut.Translator {
+	return translatorFor(r.Header.Get("Accept-Language"))
+}
 
-	validate.RegisterTagNameFunc(func(fld reflect.StructField) string {
-		name := strings.SplitN(fld.Tag.Get("json"), ",", 2)[0]
-		if name == "-" {
-			return ""
+// bindInput decodes the body into v, then sets the path, query and header tagged fields, which are
+// never taken from the body, and validates the result
+func (r *Request) bindInput(v interface{}) error {
+	if r.Body != nil && r.ContentLength != 0 {
+		if err := r.Bind(v); err != nil && err != io.EOF {
+			return handleValidationErrors(err, r.validationTranslator())
 		}
-
-		return name
-	})
-
-	for _, sValidation := range structValidations {
-		validate.RegisterStructValidation(sValidation, s)
 	}
 
-	validate.RegisterCustomTypeFunc(validateUUID, uuid.UUID{})
-
-	if err := validate.Struct(s); err != nil {
-		return handleValidationErrors(err)
+	value := reflect.ValueOf(v).Elem()
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+	if err := r.bindFields(value); err != nil {
+		return err
+	}
+	if err := validateStruct(v, r.validationTranslator()); err != nil {
+		return err
 	}
-
 	return nil
 }
 
-func validateUUID(field reflect.Value) interface{} {
-	if valuer, ok := field.Interface().(uuid.UUID); ok {
-		if valuer == uuid.Nil {
-			return nil
-		}
-		return valuer.String()
+// validateStruct validates s with the shared validator, or with one built for the call when it has
+// struct validations
+func validateStruct(s interface{}, translator ut.Translator, structValidations ...validator.StructLevelFunc) ValidationErrorInterface {
+	translator = registerTranslator(translator)
+
+	var err error
+	if len(structValidations) > 0 {
+		err = callValidator(s, translator, structValidations).Struct(s)
+	} else {
+		validateMu.RLock()
+		err = validate.Struct(s)
+		validateMu.RUnlock()
+	}
+
+	if err != nil {
+		return handleValidationErrors(err, translator)
 	}
 	return nil
 }
 
-func handleValidationErrors(err error) ValidationErrorInterface {
+func handleValidationErrors(err error, translator ut.Translator) ValidationErrorInterface {
 	switch e := err.(type) {
 	case *json.UnmarshalTypeError:
 		return ErrInvalidType(e.Field, e.Type, e)
 	case validator.ValidationErrors:
-		var msgs []string
-		for _, fe := range e {
-			switch fe.Tag() {
-			case "required":
-				msgs = append(msgs, fmt.Sprintf("%s is a required field", fe.Field()))
-			case "notblank":
-				msgs = append(msgs, fmt.Sprintf("%s should not be empty", fe.Field()))
-			case "max":
-				msgs = append(msgs, fmt.Sprintf("%s must be a maximum of %s in length", fe.Field(), fe.Param()))
-			case "url":
-				msgs = append(msgs, fmt.Sprintf("%s must be a valid URL", fe.Field()))
-			case "uuid":
-				msgs = append(msgs, fmt.Sprintf("%s must be a valid uuid", fe.Field()))
-			case "date":
-				msgs = append(msgs, fmt.Sprintf("%s must be a valid date", fe.Field()))
-			default:
-				msgs = append(msgs, fmt.Sprintf("validation failed for %s on %s", fe.Field(), fe.Tag()))
-			}
-		}
-		return ErrInvalidValue(strings.Join(msgs, ", "), e)
+		return invalidValueError(fieldErrors(e, translator), e)
 	case *json.SyntaxError:
 		return ErrInvalidJson(e)
 	default:
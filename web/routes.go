@@ -0,0 +1,69 @@
+package web
+
+import (
+	"net/http"
+	"reflect"
+)
+
+// Route documents an endpoint for the OpenAPI specification
+type Route struct {
+	Method string
+	// Path uses the router syntax, e.g. /users/:id
+	Path        string
+	OperationID string
+	Summary     string
+	Description string
+	Tags        []string
+	Deprecated  bool
+
+	// Request is a value of the input type, bound as the typed endpoints do: json fields form the
+	// body and fields tagged path, query or header the parameters
+	Request interface{}
+	// Response is a value of the type returned in the data of the success envelope
+	Response interface{}
+	// Status of the success response, defaults to 200
+	Status int
+	// Errors lists the error codes returned by the endpoint, their status comes from the error registry
+	Errors []string
+	// Version of the responses of typed endpoints, defaults to V1Api
+	Version ApiVersion
+}
+
+// HandleRoute registers the endpoint like Handle and documents it in the OpenAPI specification
+func (s *Server) HandleRoute(route Route, endpoint Endpoint, middlewares ...Middleware) {
+	if route.Status == 0 {
+		route.Status = http.StatusOK
+	}
+
+	s.mu.Lock()
+	s.routes = append(s.routes, route)
+	s.mu.Unlock()
+
+	s.Handle(route.Method, route.Path, endpoint, middlewares...)
+}
+
+// HandleTyped registers the typed handler responding with route.Status, documented with its In and Out types
+func HandleTyped[In any, Out any](s *Server, route Route, handler TypedEndpoint[In, Out], middlewares ...Middleware) {
+	if route.Request == nil && reflect.TypeOf((*In)(nil)).Elem().Kind() == reflect.Struct {
+		route.Request = *new(In)
+	}
+	if route.Response == nil {
+		route.Response = *new(Out)
+	}
+	if route.Status == 0 {
+		route.Status = http.StatusOK
+	}
+	if route.Version == "" {
+		route.Version = V1Api
+	}
+
+	s.HandleRoute(route, TypedWithStatus(route.Version, route.Status, handler), middlewares...)
+}
+
+// Routes returns the documented routes in registration order
+func (s *Server) Routes() []Route {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Route(nil), s.routes...)
+}
@@ -0,0 +1,261 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/newrelic/go-agent/v3/integrations/nrhttprouter"
+	"github.com/newrelic/go-agent/v3/newrelic"
+
+	"github.com/owlify/sparrow/logger"
+)
+
+const (
+	defaultAddr                = ":8080"
+	defaultReadTimeout         = 15 * time.Second
+	defaultWriteTimeout        = 15 * time.Second
+	defaultIdleTimeout         = 60 * time.Second
+	defaultShutdownGracePeriod = 30 * time.Second
+	defaultHealthPath          = "/health"
+	defaultReadinessPath       = "/ready"
+)
+
+type ServerOpts struct {
+	// Addr defaults to :8080
+	Addr string
+	// ReadTimeout, WriteTimeout and IdleTimeout default to 15s, 15s and 60s
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+
+	// ShutdownGracePeriod bounds how long in-flight requests are drained on shutdown, defaults to 30s
+	ShutdownGracePeriod time.Duration
+	// DrainDelay keeps serving while readiness fails on shutdown, giving load balancers time to
+	// stop sending traffic before the listener closes
+	DrainDelay time.Duration
+
+	// HealthPath and ReadinessPath default to /health and /ready. They are registered without the
+	// Middlewares so that probes are never rate limited or authenticated.
+	HealthPath    string
+	ReadinessPath string
+	// DisableSignalHandling leaves SIGTERM and SIGINT to the caller, Start then only stops once its
+	// ctx is cancelled
+	DisableSignalHandling bool
+
+	// Middlewares wrap every endpoint registered through Handle, see middlewares.Defaults
+	Middlewares []Middleware
+	// NewRelicApp instruments the router, transactions are not recorded when nil
+	NewRelicApp *newrelic.Application
+}
+
+// Closer releases a resource such as the DB, cache or a consumer on shutdown
+type Closer func(context.Context) error
+
+type Server struct {
+	opts     *ServerOpts
+	router   *nrhttprouter.Router
+	server   *http.Server
+	draining int32
+
+	mu      sync.Mutex
+	checks  []readinessCheck
+	closers []namedCloser
+	routes  []Route
+}
+
+type readinessCheck struct {
+	name  string
+	check func(context.Context) error
+}
+
+type namedCloser struct {
+	name   string
+	closer Closer
+}
+
+type readiness struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+func NewServer(serverOpts *ServerOpts) *Server {
+	opts := &ServerOpts{}
+	*opts = *serverOpts
+	if opts.Addr == "" {
+		opts.Addr = defaultAddr
+	}
+	if opts.ReadTimeout <= 0 {
+		opts.ReadTimeout = defaultReadTimeout
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = defaultWriteTimeout
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = defaultIdleTimeout
+	}
+	if opts.ShutdownGracePeriod <= 0 {
+		opts.ShutdownGracePeriod = defaultShutdownGracePeriod
+	}
+	if opts.HealthPath == "" {
+		opts.HealthPath = defaultHealthPath
+	}
+	if opts.ReadinessPath == "" {
+		opts.ReadinessPath = defaultReadinessPath
+	}
+
+	s := &Server{
+		opts:   opts,
+		router: nrhttprouter.New(opts.NewRelicApp),
+	}
+	s.server = &http.Server{
+		Addr:         opts.Addr,
+		Handler:      s.router,
+		ReadTimeout:  opts.ReadTimeout,
+		WriteTimeout: opts.WriteTimeout,
+		IdleTimeout:  opts.IdleTimeout,
+	}
+
+	// probes bypass the middlewares, see HealthPath
+	s.router.GET(opts.HealthPath, Serve(s.health))
+	s.router.GET(opts.ReadinessPath, Serve(s.readiness))
+
+	return s
+}
+
+// Router gives access to the underlying router, e.g. to mount the worker UI
+func (s *Server) Router() *nrhttprouter.Router {
+	return s.router
+}
+
+// Handle registers the endpoint wrapped by the server middlewares, then the given ones
+func (s *Server) Handle(method string, path string, endpoint Endpoint, middlewares ...Middleware) {
+	s.router.Handle(method, path, s.wrap(Serve(endpoint, middlewares...)))
+}
+
+// HandleRaw registers a plain handler wrapped by the server middlewares
+func (s *Server) HandleRaw(method string, path string, handle httprouter.Handle) {
+	s.router.Handle(method, path, s.wrap(handle))
+}
+
+// AddReadinessCheck makes the readiness route respond 503 while the check fails
+func (s *Server) AddReadinessCheck(name string, check func(context.Context) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checks = append(s.checks, readinessCheck{name: name, check: check})
+}
+
+// RegisterCloser adds a closer run on shutdown once requests are drained, in reverse registration order
+func (s *Server) RegisterCloser(name string, closer Closer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closers = append(s.closers, namedCloser{name: name, closer: closer})
+}
+
+// Start serves until ctx is cancelled or SIGTERM or SIGINT is received, then drains in-flight
+// requests for up to ShutdownGracePeriod and runs the closers. A second signal cuts the drain short.
+func (s *Server) Start(ctx context.Context) error {
+	if !s.opts.DisableSignalHandling {
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGINT)
+		defer stop()
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.server.ListenAndServe()
+	}()
+	logger.I(ctx, "[Server] listening", logger.Field("addr", s.opts.Addr))
+
+	select {
+	case err := <-serveErr:
+		s.close(context.WithoutCancel(ctx))
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx := context.WithoutCancel(ctx)
+	if !s.opts.DisableSignalHandling {
+		var stop context.CancelFunc
+		shutdownCtx, stop = signal.NotifyContext(shutdownCtx, syscall.SIGTERM, syscall.SIGINT)
+		defer stop()
+	}
+	return s.shutdown(shutdownCtx)
+}
+
+func (s *Server) shutdown(ctx context.Context) error {
+	logger.I(ctx, "[Server] shutting down")
+	atomic.StoreInt32(&s.draining, 1)
+	if s.opts.DrainDelay > 0 {
+		timer := time.NewTimer(s.opts.DrainDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+
+	drainCtx, cancel := context.WithTimeout(ctx, s.opts.ShutdownGracePeriod)
+	defer cancel()
+
+	err := s.server.Shutdown(drainCtx)
+	if err != nil {
+		logger.E(ctx, err, "[Server] failed to drain requests")
+	}
+
+	s.close(context.WithoutCancel(ctx))
+	return err
+}
+
+// close runs the closers in reverse order, a failing closer does not stop the others
+func (s *Server) close(ctx context.Context) {
+	s.mu.Lock()
+	closers := s.closers
+	s.mu.Unlock()
+
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i].closer(ctx); err != nil {
+			logger.E(ctx, err, "[Server] failed to close", logger.Field("closer", closers[i].name))
+		}
+	}
+}
+
+func (s *Server) wrap(handle httprouter.Handle) httprouter.Handle {
+	return execMiddlewares(handle, s.opts.Middlewares...)
+}
+
+func (s *Server) health(r *Request) Response {
+	return NewSuccessResponse(map[string]string{"status": "ok"}, http.StatusOK, V1Api)
+}
+
+func (s *Server) readiness(r *Request) Response {
+	if atomic.LoadInt32(&s.draining) == 1 {
+		return NewResponse(readiness{Ready: false}, false, http.StatusServiceUnavailable, V1Api)
+	}
+
+	s.mu.Lock()
+	checks := s.checks
+	s.mu.Unlock()
+
+	result := readiness{Ready: true, Checks: map[string]string{}}
+	for _, check := range checks {
+		if err := check.check(r.Context()); err != nil {
+			result.Ready = false
+			result.Checks[check.name] = err.Error()
+		} else {
+			result.Checks[check.name] = "ok"
+		}
+	}
+
+	if !result.Ready {
+		return NewResponse(result, false, http.StatusServiceUnavailable, V1Api)
+	}
+	return NewSuccessResponse(result, http.StatusOK, V1Api)
+}
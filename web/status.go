@@ -5,5 +5,6 @@ const (
 	StatusBadRequest          = 400
 	StatusUnauthorized        = 401
 	StatusNotFound            = 404
+	StatusTooManyRequests     = 429
 	StatusInternalServerError = 500
 )
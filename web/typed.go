@@ -0,0 +1,159 @@
+package web
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/owlify/sparrow/errors"
+)
+
+// TypedEndpoint receives the request bound into In and responds with Out in the success envelope.
+// In must be a struct, fields are bound from the JSON body by their json tag, then from the
+// path, query and headers by their path, query and header tags.
+type TypedEndpoint[In any, Out any] func(context.Context, *Request, In) (Out, error)
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// Typed adapts the handler to an Endpoint responding 200 on success, the responses use the version
+func Typed[In any, Out any](version ApiVersion, handler TypedEndpoint[In, Out]) Endpoint {
+	return TypedWithStatus(version, http.StatusOK, handler)
+}
+
+// TypedWithStatus adapts the handler to an Endpoint responding with status on success
+func TypedWithStatus[In any, Out any](version ApiVersion, status int, handler TypedEndpoint[In, Out]) Endpoint {
+	return func(r *Request) Response {
+		var in In
+		if err := r.bindInput(&in); err != nil {
+			return ErrorResponse(r.Context(), errors.NewWithErr(BadRequest, err), version)
+		}
+
+		out, err := handler(r.Context(), r, in)
+		if err != nil {
+			return ErrorResponse(r.Context(), err, version)
+		}
+		return NewSuccessResponse(out, status, version)
+	}
+}
+
+func (r *Request) bindFields(value reflect.Value) error {
+	valueType := value.Type()
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		fieldValue := value.Field(i)
+
+		if field.Anonymous && fieldValue.Kind() == reflect.Struct {
+			if err := r.bindFields(fieldValue); err != nil {
+				return err
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		var values []string
+		var name string
+		switch {
+		case field.Tag.Get("path") != "":
+			name = field.Tag.Get("path")
+			if param, ok := r.GetPathParams()[name]; ok {
+				values = []string{param}
+			}
+		case field.Tag.Get("query") != "":
+			name = field.Tag.Get("query")
+			values = r.URL.Query()[name]
+		case field.Tag.Get("header") != "":
+			name = field.Tag.Get("header")
+			values = r.Header.Values(name)
+		default:
+			continue
+		}
+
+		fieldValue.Set(reflect.Zero(field.Type))
+		if len(values) == 0 {
+			continue
+		}
+		if err := setField(fieldValue, values); err != nil {
+			return ErrInvalidType(name, field.Type, err)
+		}
+	}
+	return nil
+}
+
+// setField converts the raw values to the field type, slices take every value and other kinds the first
+func setField(field reflect.Value, values []string) error {
+	if field.Kind() == reflect.Slice && !field.Type().Implements(textUnmarshalerType) &&
+		!reflect.PointerTo(field.Type()).Implements(textUnmarshalerType) {
+		slice := reflect.MakeSlice(field.Type(), len(values), len(values))
+		for i, raw := range values {
+			if err := setValue(slice.Index(i), raw); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	}
+	return setValue(field, values[0])
+}
+
+func setValue(field reflect.Value, raw string) error {
+	if field.Kind() == reflect.Pointer {
+		if field.IsNil() {
+			field.Set(reflect.New(field.Type().Elem()))
+		}
+		return setValue(field.Elem(), raw)
+	}
+
+	if field.CanAddr() {
+		if unmarshaler, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return unmarshaler.UnmarshalText([]byte(raw))
+		}
+	}
+
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(duration))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(strings.TrimSpace(raw), 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(strings.TrimSpace(raw), 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(raw), field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(parsed)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
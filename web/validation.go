@@ -0,0 +1,487 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	"github.com/go-playground/validator/v10/non-standard/validators"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/owlify/sparrow/logger"
+)
+
+// FieldError describes a single validation failure, Field is the json path of the field
+type FieldError struct {
+	Field   string `json:"field"`
+	Tag     string `json:"tag"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// TranslationRegisterFunc registers the translated messages on the validator, e.g. the
+// RegisterDefaultTranslations functions of go-playground/validator/v10/translations
+type TranslationRegisterFunc func(*validator.Validate, ut.Translator) error
+
+var (
+	// validateMu guards the shared validator, validations read it while registrations write it
+	validateMu sync.RWMutex
+	validate   = newValidator()
+	// registrations replays the customisations of the shared validator on the validators built for
+	// calls with struct validations
+	registrations []func(*validator.Validate)
+
+	universalTranslator  *ut.UniversalTranslator
+	registerTranslations TranslationRegisterFunc
+	// translatedLocales records whether the translations of the locale were registered successfully
+	translatedLocales = map[string]bool{}
+)
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+	_ = v.RegisterValidation("notblank", validators.NotBlank)
+	v.RegisterTagNameFunc(fieldName)
+	v.RegisterCustomTypeFunc(validateUUID, uuid.UUID{})
+	return v
+}
+
+// RegisterValidation adds a custom tag to the validator shared by every request
+func RegisterValidation(tag string, fn validator.Func, callValidationEvenIfNull ...bool) error {
+	validateMu.Lock()
+	defer validateMu.Unlock()
+
+	if err := validate.RegisterValidation(tag, fn, callValidationEvenIfNull...); err != nil {
+		return err
+	}
+	registrations = append(registrations, func(v *validator.Validate) {
+		_ = v.RegisterValidation(tag, fn, callValidationEvenIfNull...)
+	})
+	return nil
+}
+
+// RegisterAlias makes the alias tag run the tags, e.g. RegisterAlias("phone", "e164,startswith=+91")
+func RegisterAlias(alias string, tags string) {
+	validateMu.Lock()
+	defer validateMu.Unlock()
+
+	validate.RegisterAlias(alias, tags)
+	registrations = append(registrations, func(v *validator.Validate) {
+		v.RegisterAlias(alias, tags)
+	})
+}
+
+// RegisterCustomType validates the types by the value returned by fn, as done for uuid.UUID
+func RegisterCustomType(fn validator.CustomTypeFunc, types ...interface{}) {
+	validateMu.Lock()
+	defer validateMu.Unlock()
+
+	validate.RegisterCustomTypeFunc(fn, types...)
+	registrations = append(registrations, func(v *validator.Validate) {
+		v.RegisterCustomTypeFunc(fn, types...)
+	})
+}
+
+// RegisterStructValidation runs fn on every validation of the types. It must be registered before the
+// types are first validated, as the validator caches the rules of a struct.
+func RegisterStructValidation(fn validator.StructLevelFunc, types ...interface{}) {
+	validateMu.Lock()
+	defer validateMu.Unlock()
+
+	validate.RegisterStructValidation(fn, types...)
+	registrations = append(registrations, func(v *validator.Validate) {
+		v.RegisterStructValidation(fn, types...)
+	})
+}
+
+// callValidator builds a validator configured like the shared one that also runs the struct validations
+// of a single call on s. They cannot be registered on the shared validator, which would keep running
+// the first ones it saw for the type.
+func callValidator(s interface{}, translator ut.Translator, structValidations []validator.StructLevelFunc) *validator.Validate {
+	v := newValidator()
+
+	validateMu.RLock()
+	for _, register := range registrations {
+		register(v)
+	}
+	register := registerTranslations
+	validateMu.RUnlock()
+
+	if translator != nil && register != nil {
+		// the locale registered fine on the shared validator, see registerTranslator
+		_ = register(v, translator)
+	}
+	v.RegisterStructValidation(func(sl validator.StructLevel) {
+		for _, structValidation := range structValidations {
+			structValidation(sl)
+		}
+	}, s)
+	return v
+}
+
+// SetValidationTranslator localizes the validation messages in the language of the Accept-Language
+// header, falling back to the translator's fallback locale
+func SetValidationTranslator(translator *ut.UniversalTranslator, register TranslationRegisterFunc) {
+	validateMu.Lock()
+	defer validateMu.Unlock()
+
+	universalTranslator = translator
+	registerTranslations = register
+	translatedLocales = map[string]bool{}
+}
+
+// registerTranslator registers the translations of the locale on first use, the translator is
+// dropped when they cannot be registered
+func registerTranslator(translator ut.Translator) ut.Translator {
+	if translator == nil {
+		return nil
+	}
+
+	validateMu.RLock()
+	registered, found := translatedLocales[translator.Locale()]
+	validateMu.RUnlock()
+	if found {
+		return translatorIf(translator, registered)
+	}
+
+	validateMu.Lock()
+	defer validateMu.Unlock()
+
+	if registered, found := translatedLocales[translator.Locale()]; found {
+		return translatorIf(translator, registered)
+	}
+	if registerTranslations == nil {
+		return nil
+	}
+
+	err := registerTranslations(validate, translator)
+	if err != nil {
+		logger.W(context.Background(), "[Web] failed to register validation translations",
+			zap.String("locale", translator.Locale()), zap.String("error", err.Error()))
+	}
+	translatedLocales[translator.Locale()] = err == nil
+	return translatorIf(translator, err == nil)
+}
+
+func translatorIf(translator ut.Translator, registered bool) ut.Translator {
+	if !registered {
+		return nil
+	}
+	return translator
+}
+
+// fieldMessages are the english messages of the common validator tags
+var fieldMessages = map[string]func(fe validator.FieldError) string{
+	"required":        func(fe validator.FieldError) string { return fmt.Sprintf("%s is a required field", fe.Field()) },
+	"required_if":     func(fe validator.FieldError) string { return fmt.Sprintf("%s is a required field", fe.Field()) },
+	"required_unless": func(fe validator.FieldError) string { return fmt.Sprintf("%s is a required field", fe.Field()) },
+	"required_with": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s is required when %s is present", fe.Field(), fe.Param())
+	},
+	"required_with_all": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s is required when %s are present", fe.Field(), fe.Param())
+	},
+	"required_without": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s is required when %s is missing", fe.Field(), fe.Param())
+	},
+	"required_without_all": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s is required when %s are missing", fe.Field(), fe.Param())
+	},
+	"excluded_with": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s must be empty when %s is present", fe.Field(), fe.Param())
+	},
+	"excluded_without": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s must be empty when %s is missing", fe.Field(), fe.Param())
+	},
+	"notblank": func(fe validator.FieldError) string { return fmt.Sprintf("%s should not be empty", fe.Field()) },
+	"len":      func(fe validator.FieldError) string { return sizeMessage(fe, "must be exactly") },
+	"min":      func(fe validator.FieldError) string { return sizeMessage(fe, "must be at least") },
+	"max":      func(fe validator.FieldError) string { return sizeMessage(fe, "must be a maximum of") },
+	"eq": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s must be equal to %s", fe.Field(), fe.Param())
+	},
+	"ne": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s must not be equal to %s", fe.Field(), fe.Param())
+	},
+	"gt":  func(fe validator.FieldError) string { return sizeMessage(fe, "must be greater than") },
+	"gte": func(fe validator.FieldError) string { return sizeMessage(fe, "must be at least") },
+	"lt":  func(fe validator.FieldError) string { return sizeMessage(fe, "must be less than") },
+	"lte": func(fe validator.FieldError) string { return sizeMessage(fe, "must be a maximum of") },
+	"eqfield": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s must be equal to %s", fe.Field(), fe.Param())
+	},
+	"nefield": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s must not be equal to %s", fe.Field(), fe.Param())
+	},
+	"gtfield": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s must be greater than %s", fe.Field(), fe.Param())
+	},
+	"gtefield": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s must be greater than or equal to %s", fe.Field(), fe.Param())
+	},
+	"ltfield": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s must be less than %s", fe.Field(), fe.Param())
+	},
+	"ltefield": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s must be less than or equal to %s", fe.Field(), fe.Param())
+	},
+	"oneof": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s must be one of [%s]", fe.Field(), fe.Param())
+	},
+	"unique": func(fe validator.FieldError) string { return fmt.Sprintf("%s must contain unique values", fe.Field()) },
+	"contains": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s must contain '%s'", fe.Field(), fe.Param())
+	},
+	"excludes": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s must not contain '%s'", fe.Field(), fe.Param())
+	},
+	"startswith": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s must start with '%s'", fe.Field(), fe.Param())
+	},
+	"endswith": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s must end with '%s'", fe.Field(), fe.Param())
+	},
+	"lowercase": func(fe validator.FieldError) string { return fmt.Sprintf("%s must be lowercase", fe.Field()) },
+	"uppercase": func(fe validator.FieldError) string { return fmt.Sprintf("%s must be uppercase", fe.Field()) },
+	"alpha":     func(fe validator.FieldError) string { return fmt.Sprintf("%s can only contain letters", fe.Field()) },
+	"alphanum": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s can only contain letters and numbers", fe.Field())
+	},
+	"numeric": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s must be a valid numeric value", fe.Field())
+	},
+	"number":      func(fe validator.FieldError) string { return fmt.Sprintf("%s must be a valid number", fe.Field()) },
+	"boolean":     func(fe validator.FieldError) string { return fmt.Sprintf("%s must be a valid boolean", fe.Field()) },
+	"hexadecimal": func(fe validator.FieldError) string { return fmt.Sprintf("%s must be a valid hexadecimal", fe.Field()) },
+	"base64": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s must be a valid base64 string", fe.Field())
+	},
+	"json": func(fe validator.FieldError) string { return fmt.Sprintf("%s must be a valid json string", fe.Field()) },
+	"email": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s must be a valid email address", fe.Field())
+	},
+	"e164": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s must be a valid E.164 phone number", fe.Field())
+	},
+	"url":  func(fe validator.FieldError) string { return fmt.Sprintf("%s must be a valid URL", fe.Field()) },
+	"uri":  func(fe validator.FieldError) string { return fmt.Sprintf("%s must be a valid URI", fe.Field()) },
+	"uuid": func(fe validator.FieldError) string { return fmt.Sprintf("%s must be a valid uuid", fe.Field()) },
+	"uuid4": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s must be a valid version 4 uuid", fe.Field())
+	},
+	"ip": func(fe validator.FieldError) string { return fmt.Sprintf("%s must be a valid IP address", fe.Field()) },
+	"ipv4": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s must be a valid IPv4 address", fe.Field())
+	},
+	"ipv6": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s must be a valid IPv6 address", fe.Field())
+	},
+	"date": func(fe validator.FieldError) string { return fmt.Sprintf("%s must be a valid date", fe.Field()) },
+	"datetime": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s must match the format %s", fe.Field(), fe.Param())
+	},
+	"timezone": func(fe validator.FieldError) string { return fmt.Sprintf("%s must be a valid timezone", fe.Field()) },
+	"iso3166_1_alpha2": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s must be a valid country code", fe.Field())
+	},
+	"iso4217": func(fe validator.FieldError) string {
+		return fmt.Sprintf("%s must be a valid currency code", fe.Field())
+	},
+}
+
+// sizeMessage words length checks on strings and collections differently from value checks on numbers
+func sizeMessage(fe validator.FieldError, comparison string) string {
+	switch fe.Kind() {
+	case reflect.String:
+		return fmt.Sprintf("%s %s %s characters in length", fe.Field(), comparison, fe.Param())
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return fmt.Sprintf("%s %s %s items", fe.Field(), comparison, fe.Param())
+	default:
+		return fmt.Sprintf("%s %s %s", fe.Field(), comparison, fe.Param())
+	}
+}
+
+// fieldName names the fields in errors by their json tag, or their header, query or path tag
+func fieldName(field reflect.StructField) string {
+	for _, tag := range []string{"json", "header", "query", "path"} {
+		name := strings.SplitN(field.Tag.Get(tag), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+func validateUUID(field reflect.Value) interface{} {
+	if valuer, ok := field.Interface().(uuid.UUID); ok {
+		if valuer == uuid.Nil {
+			return nil
+		}
+		return valuer.String()
+	}
+	return nil
+}
+
+// validateMap validates the values of data, as read by ReadBody, against the rules
+func validateMap(data map[string]interface{}, rules map[string]interface{}, translator ut.Translator) ValidationErrorInterface {
+	translator = registerTranslator(translator)
+
+	validateMu.RLock()
+	errs := validate.ValidateMap(numbersOf(data), rules)
+	validateMu.RUnlock()
+
+	if len(errs) == 0 {
+		return nil
+	}
+	fields, validationErrs := mapFieldErrors("", errs, translator)
+	return invalidValueError(fields, validationErrs)
+}
+
+// numbersOf copies data with the json.Number values of ReadBody converted, so that rules such as
+// gte compare numbers rather than their length
+func numbersOf(data map[string]interface{}) map[string]interface{} {
+	converted := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		switch v := value.(type) {
+		case json.Number:
+			if i, err := v.Int64(); err == nil {
+				converted[key] = i
+			} else if f, err := v.Float64(); err == nil {
+				converted[key] = f
+			} else {
+				converted[key] = v.String()
+			}
+		case map[string]interface{}:
+			converted[key] = numbersOf(v)
+		default:
+			converted[key] = value
+		}
+	}
+	return converted
+}
+
+// mapFieldErrors flattens the nested errors of ValidateMap, sorted by field path
+func mapFieldErrors(prefix string, errs map[string]interface{}, translator ut.Translator) ([]FieldError, validator.ValidationErrors) {
+	keys := make([]string, 0, len(errs))
+	for key := range errs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var fields []FieldError
+	var validationErrs validator.ValidationErrors
+	for _, key := range keys {
+		path := prefix + key
+		switch err := errs[key].(type) {
+		case validator.ValidationErrors:
+			for _, fe := range err {
+				fields = append(fields, FieldError{
+					Field: path,
+					Tag:   fe.Tag(),
+					Param: fe.Param(),
+					// map values are validated as variables which have no name for the translations to use
+					Message: fieldMessage(namedFieldError{FieldError: fe, name: path}, nil),
+				})
+				validationErrs = append(validationErrs, fe)
+			}
+		case map[string]interface{}:
+			nestedFields, nestedErrs := mapFieldErrors(path+".", err, translator)
+			fields = append(fields, nestedFields...)
+			validationErrs = append(validationErrs, nestedErrs...)
+		case error:
+			fields = append(fields, FieldError{Field: path, Tag: "object", Message: fmt.Sprintf("%s must be an object", path)})
+		}
+	}
+	return fields, validationErrs
+}
+
+// namedFieldError names the errors of validated variables
+type namedFieldError struct {
+	validator.FieldError
+	name string
+}
+
+func (e namedFieldError) Field() string { return e.name }
+
+func (e namedFieldError) Namespace() string { return e.name }
+
+// translatorFor picks the translator of the first supported Accept-Language locale
+func translatorFor(acceptLanguage string) ut.Translator {
+	validateMu.RLock()
+	defer validateMu.RUnlock()
+
+	if universalTranslator == nil {
+		return nil
+	}
+
+	var locales []string
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		locale := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		if locale != "" {
+			locales = append(locales, strings.ReplaceAll(locale, "-", "_"))
+		}
+	}
+
+	translator, _ := universalTranslator.FindTranslator(locales...)
+	return translator
+}
+
+func invalidValueError(fields []FieldError, err error) *ValidationError {
+	msgs := make([]string, 0, len(fields))
+	for _, field := range fields {
+		msgs = append(msgs, field.Message)
+	}
+	return &ValidationError{
+		errorType: "InvalidValue",
+		message:   fmt.Sprintf("InvalidValue: %s", strings.Join(msgs, ", ")),
+		err:       err,
+		fields:    fields,
+	}
+}
+
+func fieldErrors(errs validator.ValidationErrors, translator ut.Translator) []FieldError {
+	fields := make([]FieldError, 0, len(errs))
+	for _, fe := range errs {
+		fields = append(fields, FieldError{
+			Field:   fieldPath(fe),
+			Tag:     fe.Tag(),
+			Param:   fe.Param(),
+			Message: fieldMessage(fe, translator),
+		})
+	}
+	return fields
+}
+
+func fieldMessage(fe validator.FieldError, translator ut.Translator) string {
+	if translator != nil {
+		validateMu.RLock()
+		message := fe.Translate(translator)
+		validateMu.RUnlock()
+
+		// Translate falls back to the error text when no translation is registered for the tag
+		if message != fe.Error() {
+			return message
+		}
+	}
+	if message, ok := fieldMessages[fe.Tag()]; ok {
+		return message(fe)
+	}
+	return fmt.Sprintf("validation failed for %s on %s", fe.Field(), fe.Tag())
+}
+
+// fieldPath is the namespace of the field without the name of the validated struct
+func fieldPath(fe validator.FieldError) string {
+	if _, path, found := strings.Cut(fe.Namespace(), "."); found {
+		return path
+	}
+	return fe.Field()
+}
@@ -0,0 +1,158 @@
+package web
+
+import (
+	stderrors "errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+)
+
+type searchParams struct {
+	From  int    `json:"from,string" validate:"gte=0"`
+	To    int    `json:"to,string" validate:"gte=0"`
+	Query string `json:"q" validate:"required"`
+}
+
+func rangeValidation(sl validator.StructLevel) {
+	params := sl.Current().Interface().(searchParams)
+	if params.To < params.From {
+		sl.ReportError(params.To, "to", "To", "gtefield", "from")
+	}
+}
+
+func queryValidation(forbidden string) validator.StructLevelFunc {
+	return func(sl validator.StructLevel) {
+		if sl.Current().FieldByName("Query").String() == forbidden {
+			sl.ReportError(forbidden, "q", "Query", "ne", forbidden)
+		}
+	}
+}
+
+func fieldsOf(t *testing.T, err error) []FieldError {
+	t.Helper()
+	var validationErr *ValidationError
+	if !stderrors.As(err, &validationErr) {
+		t.Fatalf("error %v is not a ValidationError", err)
+	}
+	return validationErr.Fields()
+}
+
+func searchRequest(query string) *Request {
+	return NewRequest(httptest.NewRequest(http.MethodGet, "/search?"+query, nil))
+}
+
+func TestParseAndValidateParamsRunsTheValidationsOfEachCall(t *testing.T) {
+	// the type is validated before any struct validation is given, the validator then caches it
+	if err := searchRequest("q=owl&from=1&to=2").ParseAndValidateParams(&searchParams{}); err != nil {
+		t.Fatalf("valid params: %v", err)
+	}
+
+	err := searchRequest("q=owl&from=5&to=2").ParseAndValidateParams(&searchParams{}, rangeValidation)
+	fields := fieldsOf(t, err)
+	if len(fields) != 1 || fields[0].Field != "to" || fields[0].Tag != "gtefield" {
+		t.Errorf("fields = %+v, want the range validation", fields)
+	}
+
+	// every validation of the call runs, and only those of the call
+	err = searchRequest("q=cat&from=5&to=2").ParseAndValidateParams(&searchParams{}, queryValidation("cat"), rangeValidation)
+	if fields := fieldsOf(t, err); len(fields) != 2 {
+		t.Errorf("fields = %+v, want both validations", fields)
+	}
+	err = searchRequest("q=owl&from=5&to=2").ParseAndValidateParams(&searchParams{}, queryValidation("owl"))
+	fields = fieldsOf(t, err)
+	if len(fields) != 1 || fields[0].Field != "q" || fields[0].Tag != "ne" {
+		t.Errorf("fields = %+v, want the query validation only", fields)
+	}
+
+	if err := searchRequest("q=owl&from=5&to=2").ParseAndValidateParams(&searchParams{}); err != nil {
+		t.Errorf("no validation given: %v", err)
+	}
+}
+
+func TestParseAndValidateParamsCombinesTagAndStructErrors(t *testing.T) {
+	err := searchRequest("from=5&to=2").ParseAndValidateParams(&searchParams{}, rangeValidation)
+
+	fields := fieldsOf(t, err)
+	if len(fields) != 2 || fields[0].Field != "q" || fields[1].Field != "to" {
+		t.Errorf("fields = %+v, want q then to", fields)
+	}
+	if !strings.Contains(err.Error(), "q is a required field") {
+		t.Errorf("error = %q", err.Error())
+	}
+}
+
+func TestStructValidationsConcurrently(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(withRange bool) {
+			defer wg.Done()
+			var structValidations []validator.StructLevelFunc
+			if withRange {
+				structValidations = append(structValidations, rangeValidation)
+			}
+			err := searchRequest("q=owl&from=5&to=2").ParseAndValidateParams(&searchParams{}, structValidations...)
+			if (err != nil) != withRange {
+				t.Errorf("with range validation = %v, error = %v", withRange, err)
+			}
+		}(i%2 == 0)
+	}
+	wg.Wait()
+}
+
+type clientHeaders struct {
+	ClientID string `header:"X-Client-Id" validate:"required,lowercase"`
+	Retries  int    `header:"X-Retries" validate:"lte=3"`
+}
+
+func TestParseAndValidateHeaders(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Client-Id", "Mobile")
+	r.Header.Set("X-Retries", "5")
+
+	err := NewRequest(r).ParseAndValidateHeaders(&clientHeaders{})
+
+	fields := fieldsOf(t, err)
+	if len(fields) != 2 || fields[0].Field != "X-Client-Id" || fields[1].Field != "X-Retries" {
+		t.Errorf("fields = %+v, want the headers by name", fields)
+	}
+}
+
+func TestRegisterValidationIsShared(t *testing.T) {
+	type payload struct {
+		Slug string `json:"slug" validate:"owl_slug"`
+	}
+	if err := RegisterValidation("owl_slug", func(fl validator.FieldLevel) bool {
+		return !strings.ContainsAny(fl.Field().String(), " /")
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := validateStruct(&payload{Slug: "barn-owl"}, nil); e != nil {
+		t.Errorf("valid slug: %v", e)
+	}
+	fields := fieldsOf(t, validateStruct(&payload{Slug: "barn owl"}, nil))
+	if len(fields) != 1 || fields[0].Field != "slug" || fields[0].Tag != "owl_slug" {
+		t.Errorf("fields = %+v", fields)
+	}
+}
+
+func TestValidateMap(t *testing.T) {
+	body := map[string]interface{}{
+		"name":    "",
+		"address": map[string]interface{}{"city": "Pune"},
+	}
+	rules := map[string]interface{}{
+		"name":    "required",
+		"address": map[string]interface{}{"city": "required", "zip": "required"},
+	}
+
+	fields := fieldsOf(t, validateMap(body, rules, nil))
+	if len(fields) != 2 || fields[0].Field != "address.zip" || fields[1].Field != "name" {
+		t.Errorf("fields = %+v, want address.zip then name", fields)
+	}
+}
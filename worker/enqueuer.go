@@ -7,10 +7,13 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/hibiken/asynq"
+
+	"github.com/owlify/sparrow/redisconn"
 )
 
 var (
 	enqueuerInstance *enqueuer
+	enqueuerErr      error
 	once             sync.Once
 )
 
@@ -21,18 +24,39 @@ type enqueuer struct {
 type EnqueuerOpts struct {
 	PoolSize int
 	RedisUrl string
+	// Connection is the redis tasks are pushed to, it replaces RedisUrl and PoolSize
+	Connection *redisconn.Opts
 }
 
 type Enqueuer interface {
+	// Enqueue pushes the task without any uniqueness constraint and returns its info
+	Enqueue(*Task) (*TaskInfo, error)
+	// EnqueueUnique pushes the task unless the same one was enqueued within its UniqueTTL and
+	// returns its info, ProcessAt schedules it
+	EnqueueUnique(*Task) (*TaskInfo, error)
 	EnqueueUniqueTask(*Task) error
 	EnqueueUniqueTaskIn(*Task, time.Duration) error
+	Close() error
 }
 
+// NewEnqueuer panics when Connection is invalid.
+//
+// Deprecated: use NewEnqueuerV2, which returns the connection config error.
 func NewEnqueuer(opts *EnqueuerOpts) Enqueuer {
+	e, err := NewEnqueuerV2(opts)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// NewEnqueuerV2 returns the enqueuer shared by the process, built from the opts of the first call
+func NewEnqueuerV2(opts *EnqueuerOpts) (Enqueuer, error) {
 	once.Do(func() {
-		redisConnection := asynq.RedisClientOpt{
-			PoolSize: opts.PoolSize,
-			Addr:     opts.RedisUrl,
+		redisConnection, err := redisConnOpt(opts.Connection, opts.RedisUrl, opts.PoolSize)
+		if err != nil {
+			enqueuerErr = err
+			return
 		}
 
 		enqueuerInstance = &enqueuer{
@@ -40,41 +64,84 @@ func NewEnqueuer(opts *EnqueuerOpts) Enqueuer {
 		}
 	})
 
-	return enqueuerInstance
+	if enqueuerErr != nil {
+		return nil, enqueuerErr
+	}
+	return enqueuerInstance, nil
 }
 
-func (e *enqueuer) EnqueueUniqueTask(task *Task) error {
-	taskID := uuid.New().String()
-	bytes, err := json.Marshal(task.Payload)
-	if err != nil {
-		return err
-	}
+// Enqueue pushes the task without any uniqueness constraint
+func (e *enqueuer) Enqueue(task *Task) (*TaskInfo, error) {
+	return e.enqueue(task)
+}
 
-	asynqTask := asynq.NewTask(task.Name, bytes)
-	opts := []asynq.Option{asynq.TaskID(taskID), asynq.Unique(time.Hour), asynq.MaxRetry(task.Retry), asynq.Timeout(task.Timeout)}
-	_, err = e.client.Enqueue(
-		asynqTask,
-		opts...,
-	)
+func (e *enqueuer) EnqueueUnique(task *Task) (*TaskInfo, error) {
+	return e.enqueue(task, asynq.Unique(uniqueTTL(task)))
+}
+
+func (e *enqueuer) EnqueueUniqueTask(task *Task) error {
+	_, err := e.enqueue(task, asynq.Unique(uniqueTTL(task)))
 	return err
 }
 
 func (e *enqueuer) EnqueueUniqueTaskIn(task *Task, delay time.Duration) error {
-	taskID := uuid.New().String()
+	_, err := e.enqueue(task, asynq.Unique(uniqueTTL(task)), asynq.ProcessIn(delay))
+	return err
+}
+
+func (e *enqueuer) enqueue(task *Task, extraOpts ...asynq.Option) (*TaskInfo, error) {
 	bytes, err := json.Marshal(task.Payload)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	asynqTask := asynq.NewTask(task.Name, bytes)
-	opts := []asynq.Option{asynq.TaskID(taskID), asynq.Unique(time.Hour), asynq.MaxRetry(task.Retry), asynq.Timeout(task.Timeout), asynq.ProcessIn(delay)}
-	_, err = e.client.Enqueue(
+	opts := append(taskOptions(task), extraOpts...)
+	info, err := e.client.Enqueue(
 		asynqTask,
 		opts...,
 	)
-	return err
+	if err != nil {
+		return nil, err
+	}
+
+	return newTaskInfo(info), nil
 }
 
 func (e *enqueuer) Close() error {
 	return e.client.Close()
 }
+
+func taskOptions(task *Task) []asynq.Option {
+	taskID := task.ID
+	if taskID == "" {
+		taskID = uuid.New().String()
+	}
+
+	opts := []asynq.Option{asynq.TaskID(taskID), asynq.MaxRetry(task.Retry), asynq.Timeout(task.Timeout)}
+
+	if task.Queue != "" {
+		opts = append(opts, asynq.Queue(task.Queue))
+	}
+	if !task.ProcessAt.IsZero() {
+		opts = append(opts, asynq.ProcessAt(task.ProcessAt))
+	}
+	if !task.Deadline.IsZero() {
+		opts = append(opts, asynq.Deadline(task.Deadline))
+	}
+	if task.Retention > 0 {
+		opts = append(opts, asynq.Retention(task.Retention))
+	}
+	if task.Group != "" {
+		opts = append(opts, asynq.Group(task.Group))
+	}
+
+	return opts
+}
+
+func uniqueTTL(task *Task) time.Duration {
+	if task.UniqueTTL > 0 {
+		return task.UniqueTTL
+	}
+	return defaultUniqueTTL
+}
@@ -7,4 +7,6 @@ import (
 type Handler struct {
 	TaskName    string
 	HandlerFunc asynq.HandlerFunc
+	// RetryDelayFunc overrides WorkerOpts.RetryDelayFunc for this task
+	RetryDelayFunc asynq.RetryDelayFunc
 }
@@ -0,0 +1,34 @@
+package worker
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type Health struct {
+	Running       bool   `json:"running"`
+	Redis         bool   `json:"redis"`
+	Error         string `json:"error,omitempty"`
+	ActiveWorkers int64  `json:"active_workers"`
+	Concurrency   int    `json:"concurrency"`
+}
+
+func (h *Health) Ready() bool {
+	return h.Running && h.Redis
+}
+
+// HealthHandler reports the worker health as JSON, responding 503 until the worker is ready
+func HealthHandler(w Worker) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		health := w.Health(r.Context())
+
+		status := http.StatusOK
+		if !health.Ready() {
+			status = http.StatusServiceUnavailable
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(status)
+		_ = json.NewEncoder(rw).Encode(health)
+	})
+}
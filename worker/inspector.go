@@ -0,0 +1,197 @@
+package worker
+
+import (
+	"time"
+
+	"github.com/hibiken/asynq"
+
+	"github.com/owlify/sparrow/errors"
+	"github.com/owlify/sparrow/redisconn"
+)
+
+const (
+	TaskStatePending   = "pending"
+	TaskStateActive    = "active"
+	TaskStateScheduled = "scheduled"
+	TaskStateRetry     = "retry"
+	TaskStateArchived  = "archived"
+	TaskStateCompleted = "completed"
+
+	defaultListPageSize = 30
+)
+
+var (
+	ErrQueueNotFound = asynq.ErrQueueNotFound
+	ErrTaskNotFound  = asynq.ErrTaskNotFound
+)
+
+type InspectorOpts struct {
+	PoolSize int
+	RedisUrl string
+	// Connection is the redis holding the inspected queues, RedisUrl and PoolSize are ignored when it is set
+	Connection *redisconn.Opts
+}
+
+type QueueStats struct {
+	Queue          string        `json:"queue"`
+	MemoryUsage    int64         `json:"memory_usage"`
+	Latency        time.Duration `json:"latency"`
+	Size           int           `json:"size"`
+	Groups         int           `json:"groups"`
+	Pending        int           `json:"pending"`
+	Active         int           `json:"active"`
+	Scheduled      int           `json:"scheduled"`
+	Retry          int           `json:"retry"`
+	Archived       int           `json:"archived"`
+	Completed      int           `json:"completed"`
+	Aggregating    int           `json:"aggregating"`
+	Processed      int           `json:"processed"`
+	Failed         int           `json:"failed"`
+	ProcessedTotal int           `json:"processed_total"`
+	FailedTotal    int           `json:"failed_total"`
+	Paused         bool          `json:"paused"`
+	Timestamp      time.Time     `json:"timestamp"`
+}
+
+type inspector struct {
+	inspector *asynq.Inspector
+}
+
+type Inspector interface {
+	Queues() ([]string, error)
+	QueueStats(queue string) (*QueueStats, error)
+	ListTasks(queue string, state string, page int, size int) ([]*TaskInfo, error)
+	GetTask(queue string, id string) (*TaskInfo, error)
+	DeleteTask(queue string, id string) error
+	RunTask(queue string, id string) error
+	ArchiveTask(queue string, id string) error
+	CancelTask(id string) error
+	RunAllArchivedTasks(queue string) (int, error)
+	PauseQueue(queue string) error
+	UnpauseQueue(queue string) error
+	Close() error
+}
+
+func NewInspector(opts *InspectorOpts) (Inspector, error) {
+	redisConnection, err := redisConnOpt(opts.Connection, opts.RedisUrl, opts.PoolSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &inspector{
+		inspector: asynq.NewInspector(redisConnection),
+	}, nil
+}
+
+func (i *inspector) Queues() ([]string, error) {
+	return i.inspector.Queues()
+}
+
+func (i *inspector) QueueStats(queue string) (*QueueStats, error) {
+	info, err := i.inspector.GetQueueInfo(queue)
+	if err != nil {
+		return nil, err
+	}
+
+	return &QueueStats{
+		Queue:          info.Queue,
+		MemoryUsage:    info.MemoryUsage,
+		Latency:        info.Latency,
+		Size:           info.Size,
+		Groups:         info.Groups,
+		Pending:        info.Pending,
+		Active:         info.Active,
+		Scheduled:      info.Scheduled,
+		Retry:          info.Retry,
+		Archived:       info.Archived,
+		Completed:      info.Completed,
+		Aggregating:    info.Aggregating,
+		Processed:      info.Processed,
+		Failed:         info.Failed,
+		ProcessedTotal: info.ProcessedTotal,
+		FailedTotal:    info.FailedTotal,
+		Paused:         info.Paused,
+		Timestamp:      info.Timestamp,
+	}, nil
+}
+
+// ListTasks lists the tasks of a queue in the given state, page starts from 1
+func (i *inspector) ListTasks(queue string, state string, page int, size int) ([]*TaskInfo, error) {
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 {
+		size = defaultListPageSize
+	}
+	listOpts := []asynq.ListOption{asynq.Page(page), asynq.PageSize(size)}
+
+	var infos []*asynq.TaskInfo
+	var err error
+	switch state {
+	case TaskStatePending:
+		infos, err = i.inspector.ListPendingTasks(queue, listOpts...)
+	case TaskStateActive:
+		infos, err = i.inspector.ListActiveTasks(queue, listOpts...)
+	case TaskStateScheduled:
+		infos, err = i.inspector.ListScheduledTasks(queue, listOpts...)
+	case TaskStateRetry:
+		infos, err = i.inspector.ListRetryTasks(queue, listOpts...)
+	case TaskStateArchived:
+		infos, err = i.inspector.ListArchivedTasks(queue, listOpts...)
+	case TaskStateCompleted:
+		infos, err = i.inspector.ListCompletedTasks(queue, listOpts...)
+	default:
+		return nil, errors.NewWithCodef("bad_request", "unsupported task state %s", state)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := make([]*TaskInfo, 0, len(infos))
+	for _, info := range infos {
+		tasks = append(tasks, newTaskInfo(info))
+	}
+	return tasks, nil
+}
+
+func (i *inspector) GetTask(queue string, id string) (*TaskInfo, error) {
+	info, err := i.inspector.GetTaskInfo(queue, id)
+	if err != nil {
+		return nil, err
+	}
+	return newTaskInfo(info), nil
+}
+
+func (i *inspector) DeleteTask(queue string, id string) error {
+	return i.inspector.DeleteTask(queue, id)
+}
+
+// RunTask moves a scheduled, retry or archived task to pending so it is processed right away
+func (i *inspector) RunTask(queue string, id string) error {
+	return i.inspector.RunTask(queue, id)
+}
+
+func (i *inspector) ArchiveTask(queue string, id string) error {
+	return i.inspector.ArchiveTask(queue, id)
+}
+
+// CancelTask signals cancellation to the worker processing the task, the handler must honour its context
+func (i *inspector) CancelTask(id string) error {
+	return i.inspector.CancelProcessing(id)
+}
+
+func (i *inspector) RunAllArchivedTasks(queue string) (int, error) {
+	return i.inspector.RunAllArchivedTasks(queue)
+}
+
+func (i *inspector) PauseQueue(queue string) error {
+	return i.inspector.PauseQueue(queue)
+}
+
+func (i *inspector) UnpauseQueue(queue string) error {
+	return i.inspector.UnpauseQueue(queue)
+}
+
+func (i *inspector) Close() error {
+	return i.inspector.Close()
+}
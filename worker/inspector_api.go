@@ -0,0 +1,178 @@
+package worker
+
+import (
+	"crypto/subtle"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/newrelic/go-agent/v3/integrations/nrhttprouter"
+
+	"github.com/owlify/sparrow/errors"
+	"github.com/owlify/sparrow/logger"
+	"github.com/owlify/sparrow/web"
+)
+
+const (
+	notFound = "not_found"
+)
+
+var ErrMissingInspectorCredentials = errors.NewWithCodef("missing_inspector_credentials", "the inspector API requires a username and password")
+
+type InspectorAPIOpts struct {
+	Username string
+	Password string
+	Endpoint string
+}
+
+type inspectorAPI struct {
+	inspector Inspector
+}
+
+// SetupInspectorAPI mounts a JSON admin API for the inspector under /{Endpoint}, guarded by basic auth.
+// It is not mounted without a username and password.
+func SetupInspectorAPI(router *nrhttprouter.Router, inspector Inspector, opts *InspectorAPIOpts, middlewares ...web.Middleware) error {
+	if opts.Username == "" || opts.Password == "" {
+		return ErrMissingInspectorCredentials
+	}
+
+	api := &inspectorAPI{inspector: inspector}
+	middlewares = append([]web.Middleware{inspectorBasicAuth(opts)}, middlewares...)
+	prefix := fmt.Sprintf("/%s", opts.Endpoint)
+
+	router.GET(prefix+"/queues", web.Serve(api.listQueues, middlewares...))
+	router.GET(prefix+"/queues/:queue", web.Serve(api.queueStats, middlewares...))
+	router.POST(prefix+"/queues/:queue/pause", web.Serve(api.pauseQueue, middlewares...))
+	router.POST(prefix+"/queues/:queue/unpause", web.Serve(api.unpauseQueue, middlewares...))
+	router.POST(prefix+"/queues/:queue/archived/run", web.Serve(api.runAllArchivedTasks, middlewares...))
+	router.GET(prefix+"/queues/:queue/tasks", web.Serve(api.listTasks, middlewares...))
+	router.GET(prefix+"/queues/:queue/tasks/:id", web.Serve(api.getTask, middlewares...))
+	router.DELETE(prefix+"/queues/:queue/tasks/:id", web.Serve(api.deleteTask, middlewares...))
+	router.POST(prefix+"/queues/:queue/tasks/:id/run", web.Serve(api.runTask, middlewares...))
+	router.POST(prefix+"/queues/:queue/tasks/:id/archive", web.Serve(api.archiveTask, middlewares...))
+	router.POST(prefix+"/tasks/:id/cancel", web.Serve(api.cancelTask, middlewares...))
+	return nil
+}
+
+func inspectorBasicAuth(opts *InspectorAPIOpts) web.Middleware {
+	return func(next httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			user, pass, ok := r.BasicAuth()
+			// both are compared, in constant time, so that the response time does not reveal either
+			validUser := subtle.ConstantTimeCompare([]byte(user), []byte(opts.Username))
+			validPass := subtle.ConstantTimeCompare([]byte(pass), []byte(opts.Password))
+			if !ok || validUser&validPass != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="Authorization required"`)
+				web.WriteJsonResponse(w, web.ErrUnauthenticatedRequest("invalid credentials", web.V1Api))
+				return
+			}
+
+			next(w, r, ps)
+		}
+	}
+}
+
+func (a *inspectorAPI) listQueues(r *web.Request) web.Response {
+	queues, err := a.inspector.Queues()
+	if err != nil {
+		return inspectorErrorResponse(r, err)
+	}
+	return web.NewSuccessResponse(queues, http.StatusOK, web.V1Api)
+}
+
+func (a *inspectorAPI) queueStats(r *web.Request) web.Response {
+	stats, err := a.inspector.QueueStats(r.GetPathParam("queue"))
+	if err != nil {
+		return inspectorErrorResponse(r, err)
+	}
+	return web.NewSuccessResponse(stats, http.StatusOK, web.V1Api)
+}
+
+func (a *inspectorAPI) pauseQueue(r *web.Request) web.Response {
+	if err := a.inspector.PauseQueue(r.GetPathParam("queue")); err != nil {
+		return inspectorErrorResponse(r, err)
+	}
+	return web.NewSuccessResponse(nil, http.StatusOK, web.V1Api)
+}
+
+func (a *inspectorAPI) unpauseQueue(r *web.Request) web.Response {
+	if err := a.inspector.UnpauseQueue(r.GetPathParam("queue")); err != nil {
+		return inspectorErrorResponse(r, err)
+	}
+	return web.NewSuccessResponse(nil, http.StatusOK, web.V1Api)
+}
+
+func (a *inspectorAPI) runAllArchivedTasks(r *web.Request) web.Response {
+	count, err := a.inspector.RunAllArchivedTasks(r.GetPathParam("queue"))
+	if err != nil {
+		return inspectorErrorResponse(r, err)
+	}
+	return web.NewSuccessResponse(map[string]int{"count": count}, http.StatusOK, web.V1Api)
+}
+
+// listTasks expects ?state=<pending|active|scheduled|retry|archived|completed>&page=1&size=30
+func (a *inspectorAPI) listTasks(r *web.Request) web.Response {
+	page, _ := strconv.Atoi(r.QueryParam("page"))
+	size, _ := strconv.Atoi(r.QueryParam("size"))
+	state := r.QueryParam("state")
+	if state == "" {
+		state = TaskStatePending
+	}
+
+	tasks, err := a.inspector.ListTasks(r.GetPathParam("queue"), state, page, size)
+	if err != nil {
+		return inspectorErrorResponse(r, err)
+	}
+	return web.NewSuccessResponse(tasks, http.StatusOK, web.V1Api)
+}
+
+func (a *inspectorAPI) getTask(r *web.Request) web.Response {
+	task, err := a.inspector.GetTask(r.GetPathParam("queue"), r.GetPathParam("id"))
+	if err != nil {
+		return inspectorErrorResponse(r, err)
+	}
+	return web.NewSuccessResponse(task, http.StatusOK, web.V1Api)
+}
+
+func (a *inspectorAPI) deleteTask(r *web.Request) web.Response {
+	if err := a.inspector.DeleteTask(r.GetPathParam("queue"), r.GetPathParam("id")); err != nil {
+		return inspectorErrorResponse(r, err)
+	}
+	return web.NewSuccessResponse(nil, http.StatusOK, web.V1Api)
+}
+
+func (a *inspectorAPI) runTask(r *web.Request) web.Response {
+	if err := a.inspector.RunTask(r.GetPathParam("queue"), r.GetPathParam("id")); err != nil {
+		return inspectorErrorResponse(r, err)
+	}
+	return web.NewSuccessResponse(nil, http.StatusOK, web.V1Api)
+}
+
+func (a *inspectorAPI) archiveTask(r *web.Request) web.Response {
+	if err := a.inspector.ArchiveTask(r.GetPathParam("queue"), r.GetPathParam("id")); err != nil {
+		return inspectorErrorResponse(r, err)
+	}
+	return web.NewSuccessResponse(nil, http.StatusOK, web.V1Api)
+}
+
+func (a *inspectorAPI) cancelTask(r *web.Request) web.Response {
+	if err := a.inspector.CancelTask(r.GetPathParam("id")); err != nil {
+		return inspectorErrorResponse(r, err)
+	}
+	return web.NewSuccessResponse(nil, http.StatusOK, web.V1Api)
+}
+
+// inspectorErrorResponse logs unexpected errors instead of returning them, they may come from redis
+func inspectorErrorResponse(r *web.Request, err error) web.Response {
+	switch {
+	case stderrors.Is(err, ErrQueueNotFound), stderrors.Is(err, ErrTaskNotFound):
+		return web.NewError(notFound, err.Error(), http.StatusNotFound, web.V1Api)
+	case errors.Is(err, errors.ErrBadRequest):
+		return web.ErrBadRequest(err.Error(), web.V1Api)
+	default:
+		logger.E(r.Context(), err, "[InspectorAPI] inspector request failed", logger.Field("route", r.GetRoute()))
+		return web.ErrInternalServerError("failed to inspect the tasks", web.V1Api)
+	}
+}
@@ -1,5 +1,8 @@
 package worker
 
+// defaultQueue is the asynq queue of tasks enqueued without a queue
+const defaultQueue = "default"
+
 type Queue struct {
 	Name     string
 	Priority int
@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"fmt"
+
+	"github.com/hibiken/asynq"
+
+	"github.com/owlify/sparrow/redisconn"
+)
+
+// redisConnOpt builds the asynq connection from the shared redis config, falling back to
+// the plain address and pool size when no config is given
+func redisConnOpt(conn *redisconn.Opts, redisUrl string, poolSize int) (asynq.RedisConnOpt, error) {
+	if conn == nil {
+		return asynq.RedisClientOpt{
+			PoolSize: poolSize,
+			Addr:     redisUrl,
+		}, nil
+	}
+
+	if err := conn.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid redis connection config: %w", err)
+	}
+
+	tlsConfig, err := conn.TLSConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build redis tls config: %w", err)
+	}
+
+	switch {
+	case conn.IsCluster():
+		return asynq.RedisClusterClientOpt{
+			Addrs:        conn.Cluster.Addrs,
+			MaxRedirects: conn.Cluster.MaxRedirects,
+			Username:     conn.Username,
+			Password:     conn.Password,
+			DialTimeout:  conn.DialTimeout,
+			ReadTimeout:  conn.ReadTimeout,
+			WriteTimeout: conn.WriteTimeout,
+			TLSConfig:    tlsConfig,
+		}, nil
+	case conn.IsSentinel():
+		return asynq.RedisFailoverClientOpt{
+			MasterName:       conn.Sentinel.MasterName,
+			SentinelAddrs:    conn.Sentinel.Addrs,
+			SentinelPassword: conn.Sentinel.Password,
+			Username:         conn.Username,
+			Password:         conn.Password,
+			DB:               conn.DB,
+			DialTimeout:      conn.DialTimeout,
+			ReadTimeout:      conn.ReadTimeout,
+			WriteTimeout:     conn.WriteTimeout,
+			PoolSize:         conn.PoolSize,
+			TLSConfig:        tlsConfig,
+		}, nil
+	default:
+		return asynq.RedisClientOpt{
+			Addr:         conn.Addr,
+			Username:     conn.Username,
+			Password:     conn.Password,
+			DB:           conn.DB,
+			DialTimeout:  conn.DialTimeout,
+			ReadTimeout:  conn.ReadTimeout,
+			WriteTimeout: conn.WriteTimeout,
+			PoolSize:     conn.PoolSize,
+			TLSConfig:    tlsConfig,
+		}, nil
+	}
+}
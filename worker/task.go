@@ -2,11 +2,76 @@ package worker
 
 import (
 	"time"
+
+	"github.com/hibiken/asynq"
+)
+
+const (
+	defaultUniqueTTL = time.Hour
 )
 
 type Task struct {
+	// ID is the asynq task id, a random uuid is used when empty
+	ID      string
 	Name    string
 	Retry   int
 	Timeout time.Duration
 	Payload interface{}
+
+	// Queue is the name of one of WorkerOpts.Queues, asynq's default queue is used when empty
+	Queue string
+	// ProcessAt schedules the task for the given time, ignored when zero
+	ProcessAt time.Time
+	// Deadline is the time by which the task must be processed, ignored when zero
+	Deadline time.Time
+	// Retention keeps the completed task in the queue for the given duration
+	Retention time.Duration
+	// Group aggregates tasks of the same queue using WorkerOpts.GroupAggregator
+	Group string
+	// UniqueTTL overrides the uniqueness window of the EnqueueUnique* methods, defaults to an hour
+	UniqueTTL time.Duration
+}
+
+type TaskInfo struct {
+	ID            string
+	Queue         string
+	Type          string
+	Payload       []byte
+	State         string
+	Group         string
+	MaxRetry      int
+	Retried       int
+	LastErr       string
+	LastFailedAt  time.Time
+	Timeout       time.Duration
+	Deadline      time.Time
+	NextProcessAt time.Time
+	Retention     time.Duration
+	CompletedAt   time.Time
+	Result        []byte
+}
+
+func newTaskInfo(info *asynq.TaskInfo) *TaskInfo {
+	if info == nil {
+		return nil
+	}
+
+	return &TaskInfo{
+		ID:            info.ID,
+		Queue:         info.Queue,
+		Type:          info.Type,
+		Payload:       info.Payload,
+		State:         info.State.String(),
+		Group:         info.Group,
+		MaxRetry:      info.MaxRetry,
+		Retried:       info.Retried,
+		LastErr:       info.LastErr,
+		LastFailedAt:  info.LastFailedAt,
+		Timeout:       info.Timeout,
+		Deadline:      info.Deadline,
+		NextProcessAt: info.NextProcessAt,
+		Retention:     info.Retention,
+		CompletedAt:   info.CompletedAt,
+		Result:        info.Result,
+	}
 }
@@ -4,10 +4,11 @@ import (
 	"fmt"
 	"net/http"
 
-	"github.com/hibiken/asynq"
 	"github.com/hibiken/asynqmon"
 	"github.com/julienschmidt/httprouter"
 	"github.com/newrelic/go-agent/v3/integrations/nrhttprouter"
+
+	"github.com/owlify/sparrow/redisconn"
 )
 
 type UIOpts struct {
@@ -15,15 +16,33 @@ type UIOpts struct {
 	Password string
 	Endpoint string
 	RedisUrl string
+	// Connection is the redis asynqmon reads the queues from, RedisUrl is ignored when it is set
+	Connection *redisconn.Opts
 }
 
+// SetupUI panics when Connection is invalid.
+//
+// Deprecated: use SetupUIV2, which returns the connection config error.
 func SetupUI(router *nrhttprouter.Router, opts *UIOpts) {
+	if err := SetupUIV2(router, opts); err != nil {
+		panic(err)
+	}
+}
+
+// SetupUIV2 mounts asynqmon under /{Endpoint}, guarded by basic auth
+func SetupUIV2(router *nrhttprouter.Router, opts *UIOpts) error {
+	redisConnection, err := redisConnOpt(opts.Connection, opts.RedisUrl, 0)
+	if err != nil {
+		return err
+	}
+
 	handler := asynqmon.New(asynqmon.Options{
 		RootPath:     fmt.Sprintf("/%s", opts.Endpoint),
-		RedisConnOpt: asynq.RedisClientOpt{Addr: opts.RedisUrl},
+		RedisConnOpt: redisConnection,
 	})
 
 	router.GET(fmt.Sprintf("/%s/*a", opts.Endpoint), asynqmonBasicAuth(handler, opts))
+	return nil
 }
 
 func asynqmonBasicAuth(next http.Handler, opts *UIOpts) httprouter.Handle {
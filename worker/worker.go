@@ -2,40 +2,94 @@ package worker
 
 import (
 	"context"
+	"os/signal"
+	"runtime"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/hibiken/asynq"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/owlify/sparrow/logger"
+	"github.com/owlify/sparrow/redisconn"
+)
+
+const (
+	// defaultShutdownTimeout is asynq's default
+	defaultShutdownTimeout = 8 * time.Second
+	drainPollInterval      = 100 * time.Millisecond
 )
 
 type WorkerOpts struct {
-	PoolSize        int
-	RedisUrl        string
-	Concurrency     int
-	Queues          []*Queue
+	PoolSize    int
+	RedisUrl    string
+	Concurrency int
+	Queues      []*Queue
+	// ShutdownTimeout bounds the wait for in-flight tasks once the worker stops pulling new ones, and then
+	// asynq's wait before pushing the tasks still running back to redis. Defaults to 8 seconds.
 	ShutdownTimeout time.Duration
+
+	// Connection is the redis the server pulls tasks from, RedisUrl and PoolSize are ignored when it is set
+	Connection *redisconn.Opts
+
+	// RetryDelayFunc is the default retry backoff, asynq's exponential backoff is used when nil
+	RetryDelayFunc   asynq.RetryDelayFunc
+	GroupAggregator  asynq.GroupAggregator
+	GroupGracePeriod time.Duration
+	GroupMaxDelay    time.Duration
+	GroupMaxSize     int
+
+	// HandleSignals makes Start shut down on SIGTERM and SIGINT in addition to context cancellation
+	HandleSignals bool
+	// BeforeShutdown runs once the worker is asked to stop, before it stops pulling new tasks
+	BeforeShutdown func(context.Context)
+	// AfterDrain runs once in-flight tasks finished or ShutdownTimeout elapsed
+	AfterDrain func(context.Context)
 }
 
 type worker struct {
-	server   *asynq.Server
-	handlers []*Handler
+	server         *asynq.Server
+	redis          redis.UniversalClient
+	opts           *WorkerOpts
+	handlers       []*Handler
+	retryDelayFunc asynq.RetryDelayFunc
+	concurrency    int
+	drainTimeout   time.Duration
+
+	activeWorkers int64
+	running       int32
+	cancel        context.CancelFunc
+	done          chan struct{}
+	// stopped makes a Start following Stop return at once
+	stopped   bool
+	mu        sync.Mutex
+	closeOnce sync.Once
 }
 
 type Worker interface {
 	Start(context.Context) error
 	RegisterHandlers([]*Handler)
 	Stop()
+	Health(context.Context) *Health
 }
 
-var (
-	workerOnce     sync.Once
-	workerInstance worker
-)
-
+// NewWorker panics when Connection is invalid.
+//
+// Deprecated: use NewWorkerV2, which returns the connection config error.
 func NewWorker(opts *WorkerOpts) Worker {
-	redisClientOpts := asynq.RedisClientOpt{
-		PoolSize: opts.PoolSize,
-		Addr:     opts.RedisUrl,
+	w, err := NewWorkerV2(opts)
+	if err != nil {
+		panic(err)
+	}
+	return w
+}
+
+func NewWorkerV2(opts *WorkerOpts) (Worker, error) {
+	redisClientOpts, err := redisConnOpt(opts.Connection, opts.RedisUrl, opts.PoolSize)
+	if err != nil {
+		return nil, err
 	}
 
 	queues := map[string]int{}
@@ -43,24 +97,57 @@ func NewWorker(opts *WorkerOpts) Worker {
 		queues[q.Name] = q.Priority
 	}
 
+	w := &worker{
+		redis:          redisClientOpts.MakeRedisClient().(redis.UniversalClient),
+		opts:           opts,
+		retryDelayFunc: opts.RetryDelayFunc,
+		concurrency:    opts.Concurrency,
+	}
+	if w.retryDelayFunc == nil {
+		w.retryDelayFunc = asynq.DefaultRetryDelayFunc
+	}
+	if w.concurrency < 1 {
+		w.concurrency = runtime.NumCPU()
+	}
+	w.drainTimeout = opts.ShutdownTimeout
+	if w.drainTimeout <= 0 {
+		w.drainTimeout = defaultShutdownTimeout
+	}
+
 	// Create and configuring Asynq worker server.
-	workerServer := asynq.NewServer(redisClientOpts, asynq.Config{
-		Concurrency:     opts.Concurrency,
-		Queues:          queues,
-		ShutdownTimeout: opts.ShutdownTimeout,
+	w.server = asynq.NewServer(redisClientOpts, asynq.Config{
+		Concurrency:      w.concurrency,
+		Queues:           queues,
+		ShutdownTimeout:  opts.ShutdownTimeout,
+		RetryDelayFunc:   w.retryDelay,
+		GroupAggregator:  opts.GroupAggregator,
+		GroupGracePeriod: opts.GroupGracePeriod,
+		GroupMaxDelay:    opts.GroupMaxDelay,
+		GroupMaxSize:     opts.GroupMaxSize,
 	})
 
-	return &worker{
-		server: workerServer,
-	}
+	return w, nil
 }
 
 func (w *worker) RegisterHandlers(handlers []*Handler) {
 	w.handlers = handlers
 }
 
+// retryDelay picks the backoff registered on the task's handler, falling back to the worker default
+func (w *worker) retryDelay(n int, err error, task *asynq.Task) time.Duration {
+	for _, handler := range w.handlers {
+		if handler.TaskName == task.Type() && handler.RetryDelayFunc != nil {
+			return handler.RetryDelayFunc(n, err, task)
+		}
+	}
+
+	return w.retryDelayFunc(n, err, task)
+}
+
+// Start processes tasks until ctx is cancelled or Stop is called, then drains in-flight tasks
 func (w *worker) Start(ctx context.Context) error {
 	mux := asynq.NewServeMux()
+	mux.Use(w.trackActive)
 
 	for _, handler := range w.handlers {
 		mux.HandleFunc(
@@ -69,14 +156,116 @@ func (w *worker) Start(ctx context.Context) error {
 		)
 	}
 
-	if err := w.server.Run(mux); err != nil {
+	if w.opts.HandleSignals {
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGINT)
+		defer stop()
+	}
+
+	w.mu.Lock()
+	if w.stopped {
+		w.mu.Unlock()
+		return nil
+	}
+	ctx, w.cancel = context.WithCancel(ctx)
+	w.done = make(chan struct{})
+	w.mu.Unlock()
+	defer close(w.done)
+
+	if err := w.server.Start(mux); err != nil {
 		return err
 	}
+	atomic.StoreInt32(&w.running, 1)
+
+	<-ctx.Done()
+	w.shutdown(context.WithoutCancel(ctx))
 
 	return nil
 }
 
-func (w *worker) Stop() {
+func (w *worker) shutdown(ctx context.Context) {
+	if w.opts.BeforeShutdown != nil {
+		w.opts.BeforeShutdown(ctx)
+	}
+
+	// stop pulling new tasks and let the active ones finish before shutting the server down
 	w.server.Stop()
+	drainCtx, cancel := context.WithTimeout(ctx, w.drainTimeout)
+	w.drain(drainCtx)
+	cancel()
 	w.server.Shutdown()
+	atomic.StoreInt32(&w.running, 0)
+
+	if w.opts.AfterDrain != nil {
+		w.opts.AfterDrain(ctx)
+	}
+
+	w.closeRedis(ctx)
+}
+
+// drain waits until no task is in flight or ctx is done
+func (w *worker) drain(ctx context.Context) {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for atomic.LoadInt64(&w.activeWorkers) > 0 {
+		select {
+		case <-ctx.Done():
+			logger.W(ctx, "[Worker] shutting down with tasks in flight",
+				logger.Field("active_workers", atomic.LoadInt64(&w.activeWorkers)),
+			)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *worker) closeRedis(ctx context.Context) {
+	w.closeOnce.Do(func() {
+		if err := w.redis.Close(); err != nil {
+			logger.E(ctx, err, "[Worker] failed to close redis client")
+		}
+	})
+}
+
+// Stop asks a started worker to shut down and waits until it is drained. A worker stopped before it
+// is started does not start.
+func (w *worker) Stop() {
+	w.mu.Lock()
+	w.stopped = true
+	cancel, done := w.cancel, w.done
+	w.mu.Unlock()
+
+	if cancel == nil {
+		w.closeRedis(context.Background())
+		return
+	}
+
+	cancel()
+	<-done
+}
+
+func (w *worker) Health(ctx context.Context) *Health {
+	health := &Health{
+		Running:       atomic.LoadInt32(&w.running) == 1,
+		Redis:         true,
+		ActiveWorkers: atomic.LoadInt64(&w.activeWorkers),
+		Concurrency:   w.concurrency,
+	}
+
+	if err := w.redis.Ping(ctx).Err(); err != nil {
+		health.Redis = false
+		health.Error = err.Error()
+	}
+
+	return health
+}
+
+func (w *worker) trackActive(next asynq.Handler) asynq.Handler {
+	return asynq.HandlerFunc(func(ctx context.Context, task *asynq.Task) error {
+		atomic.AddInt64(&w.activeWorkers, 1)
+		defer atomic.AddInt64(&w.activeWorkers, -1)
+
+		return next.ProcessTask(ctx, task)
+	})
 }
@@ -0,0 +1,81 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDrainWaitsForTasksInFlight(t *testing.T) {
+	w := &worker{activeWorkers: 1}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		atomic.AddInt64(&w.activeWorkers, -1)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	w.drain(ctx)
+
+	if active := atomic.LoadInt64(&w.activeWorkers); active != 0 {
+		t.Errorf("drained with %d tasks in flight", active)
+	}
+	if ctx.Err() != nil {
+		t.Error("drain waited until the deadline")
+	}
+}
+
+func TestDrainStopsAtDeadline(t *testing.T) {
+	w := &worker{activeWorkers: 1}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	w.drain(ctx)
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("drain returned after %s, want the deadline", elapsed)
+	}
+}
+
+// healthReporter reports a fixed health
+type healthReporter struct {
+	Worker
+	health *Health
+}
+
+func (h *healthReporter) Health(context.Context) *Health {
+	return h.health
+}
+
+func TestHealthHandler(t *testing.T) {
+	tests := map[string]struct {
+		health *Health
+		status int
+	}{
+		"ready":       {health: &Health{Running: true, Redis: true, Concurrency: 4}, status: http.StatusOK},
+		"not started": {health: &Health{Redis: true}, status: http.StatusServiceUnavailable},
+		"redis down":  {health: &Health{Running: true, Error: "connection refused"}, status: http.StatusServiceUnavailable},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			HealthHandler(&healthReporter{health: test.health}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+			if rec.Code != test.status {
+				t.Errorf("status = %d, want %d", rec.Code, test.status)
+			}
+			health := &Health{}
+			if err := json.NewDecoder(rec.Body).Decode(health); err != nil {
+				t.Fatalf("body: %v", err)
+			}
+			if *health != *test.health {
+				t.Errorf("body = %+v, want %+v", health, test.health)
+			}
+		})
+	}
+}
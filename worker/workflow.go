@@ -0,0 +1,591 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/owlify/sparrow/errors"
+	"github.com/owlify/sparrow/logger"
+	"github.com/owlify/sparrow/redisconn"
+)
+
+const (
+	WorkflowStatusRunning   = "running"
+	WorkflowStatusCompleted = "completed"
+	WorkflowStatusFailed    = "failed"
+
+	StepStatusPending   = "pending"
+	StepStatusSucceeded = "succeeded"
+	StepStatusFailed    = "failed"
+
+	workflowTaskIDPrefix = "wf"
+	workflowKeyPrefix    = "sparrow:workflow"
+	defaultWorkflowTTL   = 7 * 24 * time.Hour
+)
+
+var (
+	ErrWorkflowNotFound = errors.NewWithCode("workflow_not_found")
+	ErrEmptyWorkflow    = errors.NewWithCode("empty_workflow")
+	ErrWorkflowRunning  = errors.NewWithCode("workflow_running")
+)
+
+// completeStepScript marks a step as succeeded only once and returns the number of
+// completed steps of its stage, -1 if the step had already succeeded
+var completeStepScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], ARGV[1]) == 'succeeded' then
+	return -1
+end
+redis.call('HSET', KEYS[1], ARGV[1], 'succeeded')
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return redis.call('HINCRBY', KEYS[1], ARGV[2], 1)
+`)
+
+// startStepScript starts a new attempt of a step unless it succeeded meanwhile and returns the
+// attempt number, -1 if the step had already succeeded
+var startStepScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], ARGV[1]) == 'succeeded' then
+	return -1
+end
+local attempt = redis.call('HINCRBY', KEYS[1], ARGV[2], 1)
+redis.call('HSET', KEYS[1], ARGV[1], 'pending')
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return attempt
+`)
+
+// resumeScript moves a failed workflow back to running and returns the previous status, so that
+// concurrent resumes enqueue the steps only once
+var resumeScript = redis.NewScript(`
+local status = redis.call('HGET', KEYS[1], 'status')
+if status == 'failed' then
+	redis.call('HSET', KEYS[1], 'status', 'running')
+	redis.call('HDEL', KEYS[1], 'error')
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return status
+`)
+
+// Workflow is a list of stages executed one after the other. All tasks of a stage are
+// enqueued together (fan-out) and the next stage starts once every one of them succeeded (fan-in).
+type Workflow struct {
+	Name   string
+	stages [][]*Task
+}
+
+type WorkflowOpts struct {
+	PoolSize int
+	RedisUrl string
+	// Connection is the redis holding both the queues and the workflow state, it replaces RedisUrl and PoolSize
+	Connection *redisconn.Opts
+	// StateTTL is how long workflow state is kept in redis after the last update, defaults to 7 days
+	StateTTL time.Duration
+}
+
+type WorkflowStepState struct {
+	Stage   int    `json:"stage"`
+	Index   int    `json:"index"`
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Attempt int    `json:"attempt"`
+	Error   string `json:"error,omitempty"`
+}
+
+type WorkflowState struct {
+	ID     string               `json:"id"`
+	Name   string               `json:"name"`
+	Status string               `json:"status"`
+	Stage  int                  `json:"stage"`
+	Error  string               `json:"error,omitempty"`
+	Steps  []*WorkflowStepState `json:"steps"`
+}
+
+type Orchestrator interface {
+	// Start persists the workflow and enqueues its first stage, returning the workflow id
+	Start(context.Context, *Workflow) (string, error)
+	// Resume re-enqueues the steps of the current stage which failed or whose task no longer exists,
+	// only failed workflows are resumed and running ones return ErrWorkflowRunning
+	Resume(context.Context, string) error
+	Status(context.Context, string) (*WorkflowState, error)
+	// WrapHandlers makes handlers advance the workflows their tasks belong to
+	WrapHandlers([]*Handler) []*Handler
+	Close() error
+}
+
+type orchestrator struct {
+	enqueuer  Enqueuer
+	inspector Inspector
+	redis     redis.UniversalClient
+	ttl       time.Duration
+}
+
+type workflowStep struct {
+	Name    string          `json:"name"`
+	Payload json.RawMessage `json:"payload"`
+	Retry   int             `json:"retry"`
+	Timeout time.Duration   `json:"timeout"`
+	Queue   string          `json:"queue"`
+}
+
+type workflowDefinition struct {
+	Name   string            `json:"name"`
+	Stages [][]*workflowStep `json:"stages"`
+}
+
+type workflowStepRef struct {
+	workflowID string
+	stage      int
+	index      int
+}
+
+// NewChain creates a workflow running the given tasks sequentially
+func NewChain(name string, tasks ...*Task) *Workflow {
+	wf := &Workflow{Name: name}
+	for _, task := range tasks {
+		wf.Then(task)
+	}
+	return wf
+}
+
+// Then appends a stage running the given tasks in parallel
+func (w *Workflow) Then(tasks ...*Task) *Workflow {
+	if len(tasks) > 0 {
+		w.stages = append(w.stages, tasks)
+	}
+	return w
+}
+
+// OnComplete appends the callback task executed once every previous stage succeeded
+func (w *Workflow) OnComplete(task *Task) *Workflow {
+	return w.Then(task)
+}
+
+func NewOrchestrator(enqueuer Enqueuer, opts *WorkflowOpts) (Orchestrator, error) {
+	redisConnection, err := redisConnOpt(opts.Connection, opts.RedisUrl, opts.PoolSize)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := opts.StateTTL
+	if ttl <= 0 {
+		ttl = defaultWorkflowTTL
+	}
+
+	return &orchestrator{
+		enqueuer:  enqueuer,
+		inspector: &inspector{inspector: asynq.NewInspector(redisConnection)},
+		redis:     redisConnection.MakeRedisClient().(redis.UniversalClient),
+		ttl:       ttl,
+	}, nil
+}
+
+func (o *orchestrator) Start(ctx context.Context, wf *Workflow) (string, error) {
+	if len(wf.stages) == 0 {
+		return "", ErrEmptyWorkflow
+	}
+
+	definition := &workflowDefinition{Name: wf.Name}
+	for _, tasks := range wf.stages {
+		stage := make([]*workflowStep, 0, len(tasks))
+		for _, task := range tasks {
+			payload, err := json.Marshal(task.Payload)
+			if err != nil {
+				return "", err
+			}
+			stage = append(stage, &workflowStep{
+				Name:    task.Name,
+				Payload: payload,
+				Retry:   task.Retry,
+				Timeout: task.Timeout,
+				Queue:   task.Queue,
+			})
+		}
+		definition.Stages = append(definition.Stages, stage)
+	}
+
+	bytes, err := json.Marshal(definition)
+	if err != nil {
+		return "", err
+	}
+
+	id := uuid.New().String()
+	key := workflowKey(id)
+	fields := map[string]interface{}{
+		"definition": string(bytes),
+		"status":     WorkflowStatusRunning,
+		"stage":      0,
+	}
+	for stage, steps := range definition.Stages {
+		for index := range steps {
+			fields[stepField("step", stage, index)] = StepStatusPending
+		}
+	}
+
+	pipe := o.redis.TxPipeline()
+	pipe.HSet(ctx, key, fields)
+	pipe.Expire(ctx, key, o.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+
+	if err := o.enqueueStage(ctx, id, definition, 0); err != nil {
+		o.fail(ctx, id, err)
+		return id, err
+	}
+
+	return id, nil
+}
+
+func (o *orchestrator) Resume(ctx context.Context, id string) error {
+	key := workflowKey(id)
+	state, err := o.redis.HGetAll(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	if len(state) == 0 {
+		return ErrWorkflowNotFound
+	}
+	switch state["status"] {
+	case WorkflowStatusCompleted:
+		return nil
+	case WorkflowStatusRunning:
+		// its pending steps may still be queued or processed
+		return ErrWorkflowRunning
+	}
+
+	definition := &workflowDefinition{}
+	if err := json.Unmarshal([]byte(state["definition"]), definition); err != nil {
+		return err
+	}
+
+	stage, _ := strconv.Atoi(state["stage"])
+	indexes, err := o.stalledSteps(id, definition, stage, state)
+	if err != nil {
+		return err
+	}
+
+	status, err := resumeScript.Run(ctx, o.redis, []string{key}, o.ttl.Milliseconds()).Text()
+	if stderrors.Is(err, redis.Nil) {
+		return ErrWorkflowNotFound
+	}
+	if err != nil {
+		return err
+	}
+	switch status {
+	case WorkflowStatusCompleted:
+		return nil
+	case WorkflowStatusRunning:
+		// resumed concurrently
+		return ErrWorkflowRunning
+	}
+
+	if err := o.enqueueSteps(ctx, id, definition, stage, indexes); err != nil {
+		o.fail(ctx, id, err)
+		return err
+	}
+
+	return nil
+}
+
+func (o *orchestrator) Status(ctx context.Context, id string) (*WorkflowState, error) {
+	state, err := o.redis.HGetAll(ctx, workflowKey(id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(state) == 0 {
+		return nil, ErrWorkflowNotFound
+	}
+
+	definition := &workflowDefinition{}
+	if err := json.Unmarshal([]byte(state["definition"]), definition); err != nil {
+		return nil, err
+	}
+
+	stage, _ := strconv.Atoi(state["stage"])
+	result := &WorkflowState{
+		ID:     id,
+		Name:   definition.Name,
+		Status: state["status"],
+		Stage:  stage,
+		Error:  state["error"],
+	}
+	for s, steps := range definition.Stages {
+		for i, step := range steps {
+			attempt, _ := strconv.Atoi(state[stepField("attempt", s, i)])
+			result.Steps = append(result.Steps, &WorkflowStepState{
+				Stage:   s,
+				Index:   i,
+				Name:    step.Name,
+				Status:  state[stepField("step", s, i)],
+				Attempt: attempt,
+				Error:   state[stepField("error", s, i)],
+			})
+		}
+	}
+
+	return result, nil
+}
+
+func (o *orchestrator) WrapHandlers(handlers []*Handler) []*Handler {
+	wrapped := make([]*Handler, 0, len(handlers))
+	for _, handler := range handlers {
+		wrapped = append(wrapped, &Handler{
+			TaskName:       handler.TaskName,
+			HandlerFunc:    o.wrap(handler.HandlerFunc),
+			RetryDelayFunc: handler.RetryDelayFunc,
+		})
+	}
+	return wrapped
+}
+
+func (o *orchestrator) Close() error {
+	if err := o.inspector.Close(); err != nil {
+		return err
+	}
+	return o.redis.Close()
+}
+
+func (o *orchestrator) wrap(next asynq.HandlerFunc) asynq.HandlerFunc {
+	return func(ctx context.Context, task *asynq.Task) error {
+		taskID, _ := asynq.GetTaskID(ctx)
+		ref, ok := parseWorkflowTaskID(taskID)
+		if !ok {
+			return next(ctx, task)
+		}
+
+		if err := next(ctx, task); err != nil {
+			if isFinalAttempt(ctx, err) {
+				o.failStep(ctx, ref, err)
+			}
+			return err
+		}
+
+		o.completeStep(ctx, ref)
+		return nil
+	}
+}
+
+func (o *orchestrator) completeStep(ctx context.Context, ref *workflowStepRef) {
+	key := workflowKey(ref.workflowID)
+	done, err := completeStepScript.Run(ctx, o.redis, []string{key},
+		stepField("step", ref.stage, ref.index),
+		fmt.Sprintf("done:%d", ref.stage),
+		o.ttl.Milliseconds(),
+	).Int()
+	if err != nil {
+		logger.E(ctx, err, "[Workflow] failed to mark step as succeeded",
+			logger.Field("workflow_id", ref.workflowID),
+			logger.Field("stage", ref.stage),
+			logger.Field("index", ref.index),
+		)
+		o.fail(ctx, ref.workflowID, err)
+		return
+	}
+
+	definition, err := o.definition(ctx, ref.workflowID)
+	if err != nil {
+		logger.E(ctx, err, "[Workflow] failed to load workflow", logger.Field("workflow_id", ref.workflowID))
+		o.fail(ctx, ref.workflowID, err)
+		return
+	}
+
+	// only the step completing the stage moves the workflow forward
+	if done != len(definition.Stages[ref.stage]) {
+		return
+	}
+
+	next := ref.stage + 1
+	if next == len(definition.Stages) {
+		if err := o.redis.HSet(ctx, key, "status", WorkflowStatusCompleted).Err(); err != nil {
+			logger.E(ctx, err, "[Workflow] failed to mark workflow as completed", logger.Field("workflow_id", ref.workflowID))
+		}
+		return
+	}
+
+	if err := o.redis.HSet(ctx, key, "stage", next).Err(); err != nil {
+		logger.E(ctx, err, "[Workflow] failed to move to next stage",
+			logger.Field("workflow_id", ref.workflowID),
+			logger.Field("stage", next),
+		)
+		o.fail(ctx, ref.workflowID, err)
+		return
+	}
+	if err := o.enqueueStage(ctx, ref.workflowID, definition, next); err != nil {
+		logger.E(ctx, err, "[Workflow] failed to enqueue next stage",
+			logger.Field("workflow_id", ref.workflowID),
+			logger.Field("stage", next),
+		)
+		o.fail(ctx, ref.workflowID, err)
+	}
+}
+
+func (o *orchestrator) failStep(ctx context.Context, ref *workflowStepRef, stepErr error) {
+	key := workflowKey(ref.workflowID)
+	pipe := o.redis.TxPipeline()
+	pipe.HSet(ctx, key,
+		stepField("step", ref.stage, ref.index), StepStatusFailed,
+		stepField("error", ref.stage, ref.index), stepErr.Error(),
+		"status", WorkflowStatusFailed,
+		"error", stepErr.Error(),
+	)
+	pipe.Expire(ctx, key, o.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.E(ctx, err, "[Workflow] failed to mark step as failed",
+			logger.Field("workflow_id", ref.workflowID),
+			logger.Field("stage", ref.stage),
+			logger.Field("index", ref.index),
+		)
+	}
+}
+
+func (o *orchestrator) fail(ctx context.Context, id string, err error) {
+	if hsetErr := o.redis.HSet(ctx, workflowKey(id), "status", WorkflowStatusFailed, "error", err.Error()).Err(); hsetErr != nil {
+		logger.E(ctx, hsetErr, "[Workflow] failed to mark workflow as failed",
+			logger.Field("workflow_id", id),
+			logger.Field("error", err.Error()),
+		)
+	}
+}
+
+// enqueueStage enqueues every step of the stage
+func (o *orchestrator) enqueueStage(ctx context.Context, id string, definition *workflowDefinition, stage int) error {
+	indexes := make([]int, len(definition.Stages[stage]))
+	for index := range indexes {
+		indexes[index] = index
+	}
+	return o.enqueueSteps(ctx, id, definition, stage, indexes)
+}
+
+// enqueueSteps enqueues the given steps of the stage with a fresh attempt number, skipping the ones
+// which succeeded meanwhile
+func (o *orchestrator) enqueueSteps(ctx context.Context, id string, definition *workflowDefinition, stage int, indexes []int) error {
+	key := workflowKey(id)
+	for _, index := range indexes {
+		step := definition.Stages[stage][index]
+		attempt, err := startStepScript.Run(ctx, o.redis, []string{key},
+			stepField("step", stage, index),
+			stepField("attempt", stage, index),
+			o.ttl.Milliseconds(),
+		).Int()
+		if err != nil {
+			return err
+		}
+		if attempt < 0 {
+			continue
+		}
+
+		_, err = o.enqueuer.Enqueue(&Task{
+			ID:      workflowTaskID(id, stage, index, attempt),
+			Name:    step.Name,
+			Retry:   step.Retry,
+			Timeout: step.Timeout,
+			Queue:   step.Queue,
+			Payload: step.Payload,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stalledSteps returns the steps of the stage to enqueue again on resume: the failed ones and the
+// pending ones whose task no longer exists. Pending steps with a task are still queued or running.
+func (o *orchestrator) stalledSteps(id string, definition *workflowDefinition, stage int, state map[string]string) ([]int, error) {
+	var indexes []int
+	for index, step := range definition.Stages[stage] {
+		switch state[stepField("step", stage, index)] {
+		case StepStatusSucceeded:
+			continue
+		case StepStatusFailed:
+			indexes = append(indexes, index)
+			continue
+		}
+
+		attempt, _ := strconv.Atoi(state[stepField("attempt", stage, index)])
+		if attempt == 0 {
+			// never enqueued
+			indexes = append(indexes, index)
+			continue
+		}
+
+		queue := step.Queue
+		if queue == "" {
+			queue = defaultQueue
+		}
+		_, err := o.inspector.GetTask(queue, workflowTaskID(id, stage, index, attempt))
+		if stderrors.Is(err, ErrTaskNotFound) || stderrors.Is(err, ErrQueueNotFound) {
+			indexes = append(indexes, index)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return indexes, nil
+}
+
+func (o *orchestrator) definition(ctx context.Context, id string) (*workflowDefinition, error) {
+	bytes, err := o.redis.HGet(ctx, workflowKey(id), "definition").Bytes()
+	if stderrors.Is(err, redis.Nil) {
+		return nil, ErrWorkflowNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	definition := &workflowDefinition{}
+	if err := json.Unmarshal(bytes, definition); err != nil {
+		return nil, err
+	}
+	return definition, nil
+}
+
+func isFinalAttempt(ctx context.Context, err error) bool {
+	if stderrors.Is(err, asynq.SkipRetry) {
+		return true
+	}
+
+	retried, _ := asynq.GetRetryCount(ctx)
+	maxRetry, _ := asynq.GetMaxRetry(ctx)
+	return retried >= maxRetry
+}
+
+func workflowKey(id string) string {
+	return fmt.Sprintf("%s:%s", workflowKeyPrefix, id)
+}
+
+func stepField(kind string, stage int, index int) string {
+	return fmt.Sprintf("%s:%d:%d", kind, stage, index)
+}
+
+func workflowTaskID(id string, stage int, index int, attempt int) string {
+	return fmt.Sprintf("%s:%s:%d:%d:%d", workflowTaskIDPrefix, id, stage, index, attempt)
+}
+
+func parseWorkflowTaskID(taskID string) (*workflowStepRef, bool) {
+	parts := strings.Split(taskID, ":")
+	if len(parts) != 5 || parts[0] != workflowTaskIDPrefix {
+		return nil, false
+	}
+
+	stage, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return nil, false
+	}
+	index, err := strconv.Atoi(parts[3])
+	if err != nil {
+		return nil, false
+	}
+
+	return &workflowStepRef{workflowID: parts[1], stage: stage, index: index}, true
+}
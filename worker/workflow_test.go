@@ -0,0 +1,221 @@
+package worker
+
+import (
+	"context"
+	stderrors "errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/owlify/sparrow/environment"
+	"github.com/owlify/sparrow/logger"
+	"github.com/owlify/sparrow/sentry"
+)
+
+func TestMain(m *testing.M) {
+	logger.Init(logger.ERROR, environment.TestingEnv)
+	if err := sentry.Init(environment.TestingEnv, ""); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// recordingEnqueuer keeps the enqueued tasks instead of pushing them to asynq
+type recordingEnqueuer struct {
+	Enqueuer
+	tasks []*Task
+}
+
+func (e *recordingEnqueuer) Enqueue(task *Task) (*TaskInfo, error) {
+	e.tasks = append(e.tasks, task)
+	return &TaskInfo{ID: task.ID, Queue: task.Queue}, nil
+}
+
+// taskStore answers task lookups from the ids it holds, as the asynq inspector does
+type taskStore struct {
+	Inspector
+	ids map[string]bool
+}
+
+func (s *taskStore) GetTask(queue string, id string) (*TaskInfo, error) {
+	if !s.ids[id] {
+		return nil, ErrTaskNotFound
+	}
+	return &TaskInfo{ID: id, Queue: queue}, nil
+}
+
+func testOrchestrator(t *testing.T) (*orchestrator, *recordingEnqueuer, *taskStore) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	enqueuer := &recordingEnqueuer{}
+	tasks := &taskStore{ids: map[string]bool{}}
+	return &orchestrator{enqueuer: enqueuer, inspector: tasks, redis: client, ttl: time.Hour}, enqueuer, tasks
+}
+
+// run simulates the worker processing the task, completing or failing its step
+func run(t *testing.T, o *orchestrator, task *Task, err error) {
+	t.Helper()
+	ref, ok := parseWorkflowTaskID(task.ID)
+	if !ok {
+		t.Fatalf("task id %q is not a workflow task id", task.ID)
+	}
+	if err != nil {
+		o.failStep(context.Background(), ref, err)
+		return
+	}
+	o.completeStep(context.Background(), ref)
+}
+
+func taskNames(tasks []*Task) []string {
+	names := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		names = append(names, task.Name)
+	}
+	return names
+}
+
+func workflowStatus(t *testing.T, o *orchestrator, id string) *WorkflowState {
+	t.Helper()
+	state, err := o.Status(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	return state
+}
+
+func TestWorkflowChain(t *testing.T) {
+	o, enqueuer, _ := testOrchestrator(t)
+	ctx := context.Background()
+
+	id, err := o.Start(ctx, NewChain("signup", &Task{Name: "create_user"}, &Task{Name: "send_email", Queue: "mails"}))
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if names := taskNames(enqueuer.tasks); len(names) != 1 || names[0] != "create_user" {
+		t.Fatalf("enqueued %v, want the first step only", names)
+	}
+
+	run(t, o, enqueuer.tasks[0], nil)
+	if names := taskNames(enqueuer.tasks); len(names) != 2 || names[1] != "send_email" {
+		t.Fatalf("enqueued %v, want the second step after the first succeeded", names)
+	}
+	if queue := enqueuer.tasks[1].Queue; queue != "mails" {
+		t.Errorf("second step queue = %q, want mails", queue)
+	}
+	if state := workflowStatus(t, o, id); state.Status != WorkflowStatusRunning || state.Stage != 1 {
+		t.Errorf("status = %s at stage %d, want running at stage 1", state.Status, state.Stage)
+	}
+
+	run(t, o, enqueuer.tasks[1], nil)
+	if state := workflowStatus(t, o, id); state.Status != WorkflowStatusCompleted {
+		t.Errorf("status = %s, want completed", state.Status)
+	}
+	if len(enqueuer.tasks) != 2 {
+		t.Errorf("enqueued %v after the last step", taskNames(enqueuer.tasks))
+	}
+}
+
+func TestWorkflowFanIn(t *testing.T) {
+	o, enqueuer, _ := testOrchestrator(t)
+	ctx := context.Background()
+
+	wf := (&Workflow{Name: "report"}).
+		Then(&Task{Name: "fetch_orders"}, &Task{Name: "fetch_refunds"}).
+		OnComplete(&Task{Name: "build_report"})
+	if _, err := o.Start(ctx, wf); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if len(enqueuer.tasks) != 2 {
+		t.Fatalf("enqueued %v, want both steps of the first stage", taskNames(enqueuer.tasks))
+	}
+
+	orders, refunds := enqueuer.tasks[0], enqueuer.tasks[1]
+	run(t, o, orders, nil)
+	// a redelivered task does not count twice
+	run(t, o, orders, nil)
+	if len(enqueuer.tasks) != 2 {
+		t.Fatalf("enqueued %v before every step of the stage succeeded", taskNames(enqueuer.tasks))
+	}
+
+	run(t, o, refunds, nil)
+	if names := taskNames(enqueuer.tasks); len(names) != 3 || names[2] != "build_report" {
+		t.Fatalf("enqueued %v, want the callback once the stage succeeded", names)
+	}
+}
+
+func TestWorkflowResumeAfterFailure(t *testing.T) {
+	o, enqueuer, tasks := testOrchestrator(t)
+	ctx := context.Background()
+
+	wf := (&Workflow{Name: "sync"}).Then(
+		&Task{Name: "sync_users"},
+		&Task{Name: "sync_orders"},
+		&Task{Name: "sync_refunds"},
+		&Task{Name: "sync_invoices"},
+	)
+	id, err := o.Start(ctx, wf)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	users, orders, refunds, invoices := enqueuer.tasks[0], enqueuer.tasks[1], enqueuer.tasks[2], enqueuer.tasks[3]
+
+	// users succeeded, orders failed, refunds is still queued and the invoices task was deleted
+	run(t, o, users, nil)
+	run(t, o, orders, stderrors.New("timeout"))
+	tasks.ids[refunds.ID] = true
+
+	state := workflowStatus(t, o, id)
+	if state.Status != WorkflowStatusFailed || state.Error != "timeout" {
+		t.Fatalf("status = %s (%s), want failed (timeout)", state.Status, state.Error)
+	}
+
+	enqueuer.tasks = nil
+	if err := o.Resume(ctx, id); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	if names := taskNames(enqueuer.tasks); len(names) != 2 || names[0] != "sync_orders" || names[1] != "sync_invoices" {
+		t.Fatalf("resumed %v, want the failed step and the one without a task", names)
+	}
+	for _, task := range enqueuer.tasks {
+		if task.ID == orders.ID || task.ID == invoices.ID {
+			t.Errorf("resumed %s with the id of its first attempt", task.Name)
+		}
+	}
+
+	state = workflowStatus(t, o, id)
+	if state.Status != WorkflowStatusRunning || state.Error != "" {
+		t.Errorf("status = %s (%s), want running", state.Status, state.Error)
+	}
+	if step := state.Steps[1]; step.Status != StepStatusPending || step.Attempt != 2 {
+		t.Errorf("failed step is %s at attempt %d, want pending at attempt 2", step.Status, step.Attempt)
+	}
+	if err := o.Resume(ctx, id); !stderrors.Is(err, ErrWorkflowRunning) {
+		t.Errorf("second Resume error = %v, want ErrWorkflowRunning", err)
+	}
+
+	// the first attempt of refunds and the new attempts complete the workflow
+	run(t, o, refunds, nil)
+	for _, task := range enqueuer.tasks {
+		run(t, o, task, nil)
+	}
+	if state := workflowStatus(t, o, id); state.Status != WorkflowStatusCompleted {
+		t.Errorf("status = %s, want completed", state.Status)
+	}
+	if err := o.Resume(ctx, id); err != nil {
+		t.Errorf("Resume of a completed workflow: %v", err)
+	}
+}
+
+func TestWorkflowResumeUnknown(t *testing.T) {
+	o, _, _ := testOrchestrator(t)
+
+	if err := o.Resume(context.Background(), "missing"); !stderrors.Is(err, ErrWorkflowNotFound) {
+		t.Errorf("Resume error = %v, want ErrWorkflowNotFound", err)
+	}
+}